@@ -2,6 +2,11 @@ package config
 
 import (
 	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
@@ -9,19 +14,124 @@ import (
 type (
 	// GlobalConfig base config
 	GlobalConfig struct {
-		OSS        OssConfig               `yaml:"oss"`
-		Mail       *MailConfig             `yaml:"mail"`
-		TG         *TGConfig               `yaml:"tg"`
-		TgChatId   string                  `yaml:"tg_chat_id"`
-		NoticeMail []string                `yaml:"notice_mail"`
-		BackupConf map[string]BackupConfig `yaml:"backup"`
+		OSS    OssConfig     `yaml:"oss"`
+		S3     *S3Config     `yaml:"s3"`
+		Local  *LocalConfig  `yaml:"local"`
+		SFTP   *SFTPConfig   `yaml:"sftp"`
+		WebDAV *WebDAVConfig `yaml:"webdav"`
+		// Storages 命名的存储目标，可在任务中按名称引用
+		Storages   map[string]StorageConfig `yaml:"storages"`
+		Mail       *MailConfig              `yaml:"mail"`
+		TG         *TGConfig                `yaml:"tg"`
+		TgChatId   string                   `yaml:"tg_chat_id"`
+		NoticeMail []string                 `yaml:"notice_mail"`
+		BackupConf map[string]BackupConfig  `yaml:"backup"`
+		// StateDir 本地状态文件目录，如冷却期记录，默认 ~/.config/backup-go
+		StateDir string `yaml:"state_dir"`
+		// RateLimit 默认上传限速，存储中单独配置 rate_limit 时以存储的为准
+		RateLimit *RateLimitConfig `yaml:"rate_limit"`
 	}
 
 	BackupConfig struct {
-		BeforeCmd  string `yaml:"before_command"`
-		BackPath   string `yaml:"back_path"`
-		AfterCmd   string `yaml:"after_command"`
-		BackupTask string `yaml:"backup_task"`
+		BeforeCmd string `yaml:"before_command"`
+		BackPath  string `yaml:"back_path"`
+		// BackPaths 多个备份目录或文件，与 back_path 一起打包到同一个压缩包，各自作为顶层目录
+		BackPaths  []string `yaml:"back_paths"`
+		AfterCmd   string   `yaml:"after_command"`
+		BackupTask string   `yaml:"backup_task"`
+		// Storages 上传目标，可以是 storages 中的名称，或 oss、s3、local、sftp、webdav 直接使用对应的全局配置，默认 oss
+		Storages []string `yaml:"storages"`
+		// OSS、S3 覆盖任务所用存储的 bucket、endpoint、前缀和凭证，只有非空字段生效
+		OSS *OssConfig `yaml:"oss"`
+		S3  *S3Config  `yaml:"s3"`
+		// Stream 边压缩边上传，本地不生成压缩包，适合磁盘空间不足的主机；
+		// 数据无法重放，OSS 不会回退到 fast_endpoint
+		Stream bool `yaml:"stream"`
+		// SplitSize 不为 0 时压缩包按该大小分卷，文件名加 .001、.002 后缀，每个分卷单独上传和校验；
+		// 流式模式下分卷写入临时文件，上传后删除，最多占用一个分卷的磁盘空间
+		SplitSize ByteSize `yaml:"split_size"`
+		// KeyTemplate 对象 key 模板，支持 {host}、{task}、{yyyy}、{mm}、{dd}、{name}，
+		// 如 {host}/{task}/{yyyy}/{mm}/{name}，默认 {name} 即直接放在根目录
+		KeyTemplate string `yaml:"key_template"`
+		// Object 上传对象的存储类型、加密、ACL、元数据和标签，只对 OSS、S3 生效
+		Object ObjectConfig `yaml:"object"`
+		// Format 压缩格式 zip、tar.gz、tar.zst，默认 zip；tar 格式保留属主、权限、符号链接和扩展属性
+		Format string `yaml:"format"`
+		// Encrypt 上传前在本地加密压缩包，存储服务商无法读取内容
+		Encrypt *EncryptConfig `yaml:"encrypt"`
+		// Exclude 排除的文件，gitignore 语法，如 node_modules/、*.log；源目录中的 .backupignore 同样生效
+		Exclude []string `yaml:"exclude"`
+		// Include 不为空时只备份匹配的文件，如 *.conf、data/
+		Include []string `yaml:"include"`
+		// Symlinks 符号链接保存为链接 store 或保存指向的内容 follow，默认 store
+		Symlinks string `yaml:"symlinks"`
+		// SpecialFiles socket、FIFO、设备文件跳过 skip 或失败 fail，默认 skip
+		SpecialFiles string `yaml:"special_files"`
+		// Unreadable 无权限等无法读取的文件跳过 skip 并在通知中列出，或失败 fail，默认 fail
+		Unreadable string `yaml:"unreadable"`
+		// ChangedFiles 文件在读取过程中发生变化时记录警告 warn、重新读取 retry 或失败 fail，默认 warn，
+		// retry 最多重新读取 3 次，仍然变化时记录警告；仓库模式下重新读取前写入的块在所在 pack 整个不再被引用前不会被清理
+		ChangedFiles string `yaml:"changed_files"`
+		// CompressionLevel 压缩级别 store、fastest、default、best，store 只存储不压缩，适合图片、视频等，默认 default
+		CompressionLevel string `yaml:"compression_level"`
+		// Parallel 并行压缩的线程数，大于 1 时 zip 同时压缩多个文件，tar.gz、tar.zst 并行压缩数据块，
+		// 默认 zip、tar.gz 单线程，tar.zst 使用所有 CPU
+		Parallel int `yaml:"parallel"`
+		// Incremental 增量备份，按文件清单只打包变化的文件，定期全量备份
+		Incremental *IncrementalConfig `yaml:"incremental"`
+		// Repository 仓库模式，文件按内容切块去重后存储，每次备份生成一个快照，不再生成压缩包
+		Repository *RepositoryConfig `yaml:"repository"`
+	}
+
+	// EncryptConfig 客户端加密，使用 age 格式，Recipients 和 Passphrase 二选一
+	EncryptConfig struct {
+		// Recipients age 公钥，如 age1...，可以配置多个，任意一个对应的私钥都能解密
+		Recipients []string `yaml:"recipients"`
+		// Passphrase 口令加密，使用 scrypt 派生密钥
+		Passphrase string `yaml:"passphrase"`
+		// IdentityFile 恢复时使用的 age 私钥文件，备份主机上不需要配置
+		IdentityFile string `yaml:"identity_file"`
+	}
+
+	// IncrementalConfig 增量备份配置，每次增量都以最近一次全量备份为基准，恢复时只需要全量和最新的增量
+	IncrementalConfig struct {
+		// FullInterval 全量备份间隔天数，默认 7
+		FullInterval int `yaml:"full_interval"`
+	}
+
+	// RepositoryConfig 去重仓库配置，任务配置 encrypt 时加密 pack 和文件树，块 ID 和 pack 名为以仓库密钥计算的 HMAC；
+	// 快照的时间和主机、块的数量和压缩后大小不加密，清理时不需要私钥。仓库密钥随机生成，保存在 state_dir 并加密保存在仓库中，
+	// 更换主机时复制 state_dir 中的 repository_{task}.key 或配置 identity_file，本地密钥与仓库中的指纹不一致时备份失败。
+	// 每个对象单独加密，建议使用 recipients，passphrase 每个对象都要做一次 scrypt 较慢
+	RepositoryConfig struct {
+		// Path 仓库在存储中的目录，默认 repository/{task}
+		Path string `yaml:"path"`
+		// PackSize 打包上传的大小，默认 16MB
+		PackSize ByteSize `yaml:"pack_size"`
+		// KeepDays 快照保留天数，默认 30，最新的快照总是保留
+		KeepDays int `yaml:"keep_days"`
+	}
+
+	ObjectConfig struct {
+		// StorageClass 存储类型，OSS 为 Standard、IA、Archive、ColdArchive，S3 为 STANDARD_IA、GLACIER 等，默认使用 bucket 的设置
+		StorageClass string `yaml:"storage_class"`
+		// Encryption 服务端加密方式 AES256 或 KMS，KMS 可指定 KMSKeyID
+		Encryption string `yaml:"encryption"`
+		KMSKeyID   string `yaml:"kms_key_id"`
+		// ACL 如 private，默认继承 bucket
+		ACL string `yaml:"acl"`
+		// Metadata 自定义元数据，另外会自动附带任务 ID、主机名、备份路径和压缩包 SHA256
+		Metadata map[string]string `yaml:"metadata"`
+		Tags     map[string]string `yaml:"tags"`
+	}
+
+	// StorageConfig 命名的存储目标，只能配置其中一种
+	StorageConfig struct {
+		OSS    *OssConfig    `yaml:"oss"`
+		S3     *S3Config     `yaml:"s3"`
+		Local  *LocalConfig  `yaml:"local"`
+		SFTP   *SFTPConfig   `yaml:"sftp"`
+		WebDAV *WebDAVConfig `yaml:"webdav"`
 	}
 
 	OssConfig struct {
@@ -30,6 +140,96 @@ type (
 		AccessKeySecret string `yaml:"access_key_secret"`
 		Endpoint        string `yaml:"endpoint"`
 		FastEndpoint    string `yaml:"fast_endpoint"`
+		// Prefix 对象 key 前缀，如 db/
+		Prefix string `yaml:"prefix"`
+		// MultipartThreshold 文件超过该大小时使用可断点续传的分片上传，默认 100MB
+		MultipartThreshold ByteSize `yaml:"multipart_threshold"`
+		// PartSize 分片大小，默认 10MB
+		PartSize ByteSize `yaml:"part_size"`
+		// PartConcurrency 分片并发上传数，默认 3
+		PartConcurrency int `yaml:"part_concurrency"`
+		// CheckpointDir 断点记录目录，默认系统临时目录下的 backup-go/checkpoint；上传失败的压缩包保留到下次运行时续传
+		CheckpointDir string `yaml:"checkpoint_dir"`
+		// FastCooldown fast_endpoint 上传成功后的冷却时间，期间不再使用 fast_endpoint，默认 72h
+		FastCooldown time.Duration `yaml:"fast_cooldown"`
+		// Retry、FastRetry 分别为 endpoint 和 fast_endpoint 的重试策略
+		Retry     RetryConfig `yaml:"retry"`
+		FastRetry RetryConfig `yaml:"fast_retry"`
+		// RateLimit 上传限速，endpoint 和 fast_endpoint 共享
+		RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	}
+
+	// RetryConfig 上传重试配置
+	RetryConfig struct {
+		// Attempts 总尝试次数，默认 3
+		Attempts int `yaml:"attempts"`
+		// Backoff 首次重试前的等待时间，之后每次翻倍，默认 10s
+		Backoff time.Duration `yaml:"backoff"`
+		// MaxBackoff 最长等待时间，默认 5m
+		MaxBackoff time.Duration `yaml:"max_backoff"`
+		// Jitter 等待时间的随机抖动比例，0 到 1 之间，未配置时默认 0.2，配置为 0 时不抖动
+		Jitter *float64 `yaml:"jitter"`
+	}
+
+	// RateLimitConfig 上传限速，每个存储目标单独计算
+	RateLimitConfig struct {
+		// Limit 每秒上传字节数，如 2MB，0 表示不限速
+		Limit ByteSize `yaml:"limit"`
+		// Windows 按时间段使用不同的限速，不在任何时间段内时使用 Limit
+		Windows []RateWindowConfig `yaml:"windows"`
+	}
+
+	// RateWindowConfig 时间段限速，Start、End 为本地时间 HH:MM，End 早于 Start 时表示跨过午夜
+	RateWindowConfig struct {
+		Start string   `yaml:"start"`
+		End   string   `yaml:"end"`
+		Limit ByteSize `yaml:"limit"`
+	}
+
+	S3Config struct {
+		BucketName      string `yaml:"bucket_name"`
+		AccessKey       string `yaml:"access_key"`
+		AccessKeySecret string `yaml:"access_key_secret"`
+		// Endpoint 可带 scheme，如 http://127.0.0.1:9000，不带时默认 https
+		Endpoint string `yaml:"endpoint"`
+		Region   string `yaml:"region"`
+		// PathStyle 使用 path-style 地址，MinIO 等需要开启，任务覆盖时可以显式配置为 false
+		PathStyle *bool `yaml:"path_style"`
+		// Prefix 对象 key 前缀，如 db/
+		Prefix    string           `yaml:"prefix"`
+		RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	}
+
+	LocalConfig struct {
+		// Path 备份文件存放目录，如挂载的 NAS 目录
+		Path      string           `yaml:"path"`
+		RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	}
+
+	SFTPConfig struct {
+		Host string `yaml:"host"`
+		// Port 默认 22
+		Port int    `yaml:"port"`
+		User string `yaml:"user"`
+		// KeyFile 与 Password 至少配置一个
+		KeyFile       string `yaml:"key_file"`
+		KeyPassphrase string `yaml:"key_passphrase"`
+		Password      string `yaml:"password"`
+		// Dir 远端备份目录
+		Dir string `yaml:"dir"`
+		// KnownHosts 默认 ~/.ssh/known_hosts
+		KnownHosts            string           `yaml:"known_hosts"`
+		InsecureIgnoreHostKey bool             `yaml:"insecure_ignore_host_key"`
+		RateLimit             *RateLimitConfig `yaml:"rate_limit"`
+	}
+
+	WebDAVConfig struct {
+		// URL 备份目录地址，如 https://cloud.example.com/remote.php/dav/files/user/backup
+		URL                string           `yaml:"url"`
+		User               string           `yaml:"user"`
+		Password           string           `yaml:"password"`
+		InsecureSkipVerify bool             `yaml:"insecure_skip_verify"`
+		RateLimit          *RateLimitConfig `yaml:"rate_limit"`
 	}
 
 	TGConfig struct {
@@ -44,6 +244,221 @@ type (
 	}
 )
 
+const (
+	StorageOSS    = "oss"
+	StorageS3     = "s3"
+	StorageLocal  = "local"
+	StorageSFTP   = "sftp"
+	StorageWebDAV = "webdav"
+)
+
+// GetStorages 返回任务的上传目标，未配置时默认 oss
+func (bc BackupConfig) GetStorages() []string {
+	if len(bc.Storages) <= 0 {
+		return []string{StorageOSS}
+	}
+	return bc.Storages
+}
+
+// GetBackPaths 返回任务的所有备份路径，back_path 在前
+func (bc BackupConfig) GetBackPaths() []string {
+	var paths []string
+	if bc.BackPath != "" {
+		paths = append(paths, bc.BackPath)
+	}
+	return append(paths, bc.BackPaths...)
+}
+
+// GetFullInterval 返回全量备份间隔
+func (ic IncrementalConfig) GetFullInterval() time.Duration {
+	days := ic.FullInterval
+	if days <= 0 {
+		days = 7
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetPath 返回仓库在存储中的目录，以 / 结尾
+func (rc RepositoryConfig) GetPath(task string) string {
+	if rc.Path == "" {
+		return "repository/" + task + "/"
+	}
+	return strings.Trim(rc.Path, "/") + "/"
+}
+
+// GetKeepDays 返回快照保留天数
+func (rc RepositoryConfig) GetKeepDays() int {
+	if rc.KeepDays <= 0 {
+		return 30
+	}
+	return rc.KeepDays
+}
+
+// GetStateDir 返回本地状态文件目录
+func (gc GlobalConfig) GetStateDir() string {
+	if gc.StateDir != "" {
+		return gc.StateDir
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "backup-go")
+	}
+	return "state"
+}
+
+// ApplyOverride 使用任务中的 oss、s3 配置覆盖对应类型的存储配置
+func (bc BackupConfig) ApplyOverride(sc StorageConfig) StorageConfig {
+	if sc.OSS != nil && bc.OSS != nil {
+		merged := sc.OSS.Merge(*bc.OSS)
+		sc.OSS = &merged
+	}
+	if sc.S3 != nil && bc.S3 != nil {
+		merged := sc.S3.Merge(*bc.S3)
+		sc.S3 = &merged
+	}
+	return sc
+}
+
+// Merge 返回用 override 中非空字段覆盖后的配置
+func (oc OssConfig) Merge(override OssConfig) OssConfig {
+	setIfNotEmpty(&oc.BucketName, override.BucketName)
+	setIfNotEmpty(&oc.AccessKey, override.AccessKey)
+	setIfNotEmpty(&oc.AccessKeySecret, override.AccessKeySecret)
+	setIfNotEmpty(&oc.Endpoint, override.Endpoint)
+	setIfNotEmpty(&oc.FastEndpoint, override.FastEndpoint)
+	setIfNotEmpty(&oc.Prefix, override.Prefix)
+	setIfNotEmpty(&oc.MultipartThreshold, override.MultipartThreshold)
+	setIfNotEmpty(&oc.PartSize, override.PartSize)
+	setIfNotEmpty(&oc.PartConcurrency, override.PartConcurrency)
+	setIfNotEmpty(&oc.CheckpointDir, override.CheckpointDir)
+	setIfNotEmpty(&oc.FastCooldown, override.FastCooldown)
+	oc.Retry = oc.Retry.Merge(override.Retry)
+	oc.FastRetry = oc.FastRetry.Merge(override.FastRetry)
+	setIfNotEmpty(&oc.RateLimit, override.RateLimit)
+	return oc
+}
+
+// Merge 返回用 override 中非空字段覆盖后的配置
+func (sc S3Config) Merge(override S3Config) S3Config {
+	setIfNotEmpty(&sc.BucketName, override.BucketName)
+	setIfNotEmpty(&sc.AccessKey, override.AccessKey)
+	setIfNotEmpty(&sc.AccessKeySecret, override.AccessKeySecret)
+	setIfNotEmpty(&sc.Endpoint, override.Endpoint)
+	setIfNotEmpty(&sc.Region, override.Region)
+	setIfNotEmpty(&sc.Prefix, override.Prefix)
+	setIfNotEmpty(&sc.RateLimit, override.RateLimit)
+	setIfNotEmpty(&sc.PathStyle, override.PathStyle)
+	return sc
+}
+
+// GetPathStyle 返回是否使用 path-style 地址，默认 false
+func (sc S3Config) GetPathStyle() bool {
+	return sc.PathStyle != nil && *sc.PathStyle
+}
+
+// Merge 返回用 override 中已配置的字段逐个覆盖后的重试配置，未配置的字段保留原值
+func (rc RetryConfig) Merge(override RetryConfig) RetryConfig {
+	setIfNotEmpty(&rc.Attempts, override.Attempts)
+	setIfNotEmpty(&rc.Backoff, override.Backoff)
+	setIfNotEmpty(&rc.MaxBackoff, override.MaxBackoff)
+	setIfNotEmpty(&rc.Jitter, override.Jitter)
+	return rc
+}
+
+func setIfNotEmpty[T comparable](target *T, value T) {
+	var zero T
+	if value != zero {
+		*target = value
+	}
+}
+
+// Type 返回存储类型，未配置或配置了多种时返回空
+func (sc StorageConfig) Type() string {
+	var types []string
+	if sc.OSS != nil {
+		types = append(types, StorageOSS)
+	}
+	if sc.S3 != nil {
+		types = append(types, StorageS3)
+	}
+	if sc.Local != nil {
+		types = append(types, StorageLocal)
+	}
+	if sc.SFTP != nil {
+		types = append(types, StorageSFTP)
+	}
+	if sc.WebDAV != nil {
+		types = append(types, StorageWebDAV)
+	}
+
+	if len(types) != 1 {
+		return ""
+	}
+	return types[0]
+}
+
+// ResolveStorage 根据名称查找存储配置，优先使用命名的存储目标，其次是同名的全局配置
+func (gc GlobalConfig) ResolveStorage(name string) (StorageConfig, error) {
+	if sc, ok := gc.Storages[name]; ok {
+		if sc.Type() == "" {
+			return sc, fmt.Errorf("storage %s must config exactly one of oss, s3, local, sftp, webdav", name)
+		}
+		return sc.withRateLimit(gc.RateLimit), nil
+	}
+
+	var sc StorageConfig
+	switch name {
+	case StorageOSS:
+		sc.OSS = &gc.OSS
+	case StorageS3:
+		sc.S3 = gc.S3
+	case StorageLocal:
+		sc.Local = gc.Local
+	case StorageSFTP:
+		sc.SFTP = gc.SFTP
+	case StorageWebDAV:
+		sc.WebDAV = gc.WebDAV
+	default:
+		return sc, fmt.Errorf("unknown storage %s", name)
+	}
+
+	if sc.Type() == "" {
+		return sc, fmt.Errorf("storage %s config is empty", name)
+	}
+	return sc.withRateLimit(gc.RateLimit), nil
+}
+
+// withRateLimit 存储未单独配置限速时使用全局限速，返回的配置不修改原配置
+func (sc StorageConfig) withRateLimit(rl *RateLimitConfig) StorageConfig {
+	if rl == nil {
+		return sc
+	}
+
+	switch {
+	case sc.OSS != nil && sc.OSS.RateLimit == nil:
+		conf := *sc.OSS
+		conf.RateLimit = rl
+		sc.OSS = &conf
+	case sc.S3 != nil && sc.S3.RateLimit == nil:
+		conf := *sc.S3
+		conf.RateLimit = rl
+		sc.S3 = &conf
+	case sc.Local != nil && sc.Local.RateLimit == nil:
+		conf := *sc.Local
+		conf.RateLimit = rl
+		sc.Local = &conf
+	case sc.SFTP != nil && sc.SFTP.RateLimit == nil:
+		conf := *sc.SFTP
+		conf.RateLimit = rl
+		sc.SFTP = &conf
+	case sc.WebDAV != nil && sc.WebDAV.RateLimit == nil:
+		conf := *sc.WebDAV
+		conf.RateLimit = rl
+		sc.WebDAV = &conf
+	}
+	return sc
+}
+
 //go:embed config.yml
 var configBlob []byte
 
@@ -62,10 +477,16 @@ func InitConfig() {
 		panic("config can not be empty")
 	}
 
-	for _, v := range config.BackupConf {
-		if v.BackPath == "" {
+	for id, v := range config.BackupConf {
+		if len(v.GetBackPaths()) <= 0 {
 			panic("id or back_path can not be empty")
 		}
+
+		for _, name := range v.GetStorages() {
+			if _, err := config.ResolveStorage(name); err != nil {
+				panic(fmt.Sprintf("task %s: %v", id, err))
+			}
+		}
 	}
 
 	Config = config
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyOverride(t *testing.T) {
+	jitter := 0.5
+	on, off := true, false
+	sc := StorageConfig{
+		OSS: &OssConfig{BucketName: "bucket", Retry: RetryConfig{Attempts: 3, Backoff: time.Second, Jitter: &jitter}},
+		S3:  &S3Config{BucketName: "bucket", PathStyle: &on},
+	}
+	bc := BackupConfig{
+		OSS: &OssConfig{Retry: RetryConfig{Attempts: 5}},
+		S3:  &S3Config{PathStyle: &off},
+	}
+	merged := bc.ApplyOverride(sc)
+
+	// 只覆盖配置了的重试字段，其余保留全局配置
+	retry := merged.OSS.Retry
+	if retry.Attempts != 5 || retry.Backoff != time.Second || retry.Jitter == nil || *retry.Jitter != 0.5 {
+		t.Errorf("retry = %+v", retry)
+	}
+	if merged.S3.GetPathStyle() {
+		t.Error("path style override false should take effect")
+	}
+	if !sc.S3.GetPathStyle() {
+		t.Error("global config should not be modified")
+	}
+}
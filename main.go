@@ -3,28 +3,45 @@ package main
 import (
 	"backup-go/config"
 	"backup-go/notice"
+	"backup-go/repository"
+	"backup-go/storage"
 	"backup-go/utils"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/robfig/cron/v3"
 )
 
 type TaskHolder struct {
-	ID            string
-	conf          config.BackupConfig
-	ossClient     *OssClient
+	ID          string
+	conf        config.BackupConfig
+	storages    []*storage.NamedStorage
+	keyTemplate utils.KeyTemplate
+	archiveOpts utils.ArchiveOptions
+	// encryption 加密方式，记录到对象元数据，未加密时为空
+	encryption string
+	// repo 仓库模式的去重仓库，未配置 repository 时为空
+	repo          *repository.Repository
 	noticeManager *notice.NoticeManager
 }
 
 func defaultHolder(id string, conf config.BackupConfig) *TaskHolder {
-	if id == "" || conf.BackPath == "" {
+	if id == "" || len(conf.GetBackPaths()) <= 0 {
 		panic("id or back_path can not be empty")
 	}
+	if err := utils.ValidateKeyTemplate(conf.KeyTemplate); err != nil {
+		panic(err)
+	}
 
 	nm := notice.NewNoticeManager()
 	if config.Config.TG != nil {
@@ -37,10 +54,69 @@ func defaultHolder(id string, conf config.BackupConfig) *TaskHolder {
 		nm.AddNotifier(notice.NewMailNotifier(&ms, config.Config.NoticeMail))
 	}
 
+	var storages []*storage.NamedStorage
+	for _, name := range conf.GetStorages() {
+		sc, err := config.Config.ResolveStorage(name)
+		if err != nil {
+			panic(err)
+		}
+		storages = append(storages, &storage.NamedStorage{
+			Name:    name,
+			Storage: storage.GetStorage(conf.ApplyOverride(sc)),
+		})
+	}
+
+	archiveOpts := utils.ArchiveOptions{
+		Format:       conf.Format,
+		Exclude:      conf.Exclude,
+		Include:      conf.Include,
+		Symlinks:     conf.Symlinks,
+		SpecialFiles: conf.SpecialFiles,
+		Unreadable:   conf.Unreadable,
+		Changed:      conf.ChangedFiles,
+		Level:        conf.CompressionLevel,
+		Parallel:     conf.Parallel,
+	}
+	if err := archiveOpts.Validate(); err != nil {
+		panic(fmt.Sprintf("task %s: %v", id, err))
+	}
+	var encryption string
+	if conf.Encrypt != nil {
+		encrypt, scheme, err := utils.NewEncryptor(conf.Encrypt.Recipients, conf.Encrypt.Passphrase)
+		if err != nil {
+			panic(fmt.Sprintf("task %s: %v", id, err))
+		}
+		archiveOpts.Encrypt = encrypt
+		encryption = scheme
+	}
+
+	var repo *repository.Repository
+	if conf.Repository != nil {
+		opts := repository.Options{
+			PackSize:  int64(conf.Repository.PackSize),
+			Encrypt:   archiveOpts.Encrypt,
+			Archive:   archiveOpts,
+			CachePath: filepath.Join(config.Config.GetStateDir(), "repository_"+id+".json"),
+			KeyPath:   filepath.Join(config.Config.GetStateDir(), "repository_"+id+".key"),
+		}
+		// 本地没有仓库密钥时（如更换主机）用私钥或口令读取仓库中的密钥
+		if conf.Encrypt != nil && (conf.Encrypt.IdentityFile != "" || conf.Encrypt.Passphrase != "") {
+			identityFile, passphrase := conf.Encrypt.IdentityFile, conf.Encrypt.Passphrase
+			opts.Decrypt = func(r io.Reader) (io.Reader, error) {
+				return utils.NewDecryptReader(r, identityFile, passphrase)
+			}
+		}
+		repo = repository.New(conf.Repository.GetPath(id), storages, opts)
+	}
+
 	return &TaskHolder{
 		ID:            id,
 		conf:          conf,
-		ossClient:     CreateOSSClient(config.Config.OSS),
+		storages:      storages,
+		keyTemplate:   utils.NewKeyTemplate(conf.KeyTemplate, id),
+		archiveOpts:   archiveOpts,
+		encryption:    encryption,
+		repo:          repo,
 		noticeManager: nm,
 	}
 }
@@ -48,6 +124,13 @@ func defaultHolder(id string, conf config.BackupConfig) *TaskHolder {
 func main() {
 	config.InitConfig()
 
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := restore(os.Args[2:]); err != nil {
+			log.Fatalf("restore failed: %v", err)
+		}
+		return
+	}
+
 	secondParser := cron.NewParser(
 		cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.DowOptional | cron.Descriptor,
 	)
@@ -109,58 +192,112 @@ func (c *TaskHolder) cleanHistory() {
 
 func (c *TaskHolder) cleanHistoryWithLogger(logger *utils.TaskLogger) {
 	logger.ExecuteStep("清理历史文件", func() error {
-		ossClient := c.ossClient
-
-		var objects []oss.ObjectProperties
-		token := ""
-		for {
-			resp, err := ossClient.GetSlowClient().ListObjectsV2(oss.MaxKeys(100), oss.ContinuationToken(token))
-			if err != nil {
-				logger.LogError(err, "列出对象失败")
-				return err
-			}
-
-			for _, object := range resp.Objects {
-				need := utils.IsNeedDeleteFile(c.ID, object.Key)
-				if need {
-					objects = append(objects, object)
-				}
-			}
-			if resp.IsTruncated {
-				token = resp.NextContinuationToken
-			} else {
-				break
+		var errs []error
+		for _, st := range c.storages {
+			if err := logger.ExecuteStep("清理"+st.Name, func() error {
+				c.cleanUploads(logger, st)
+				return c.cleanStorageHistory(logger, st)
+			}); err != nil {
+				errs = append(errs, err)
 			}
 		}
 
-		if len(objects) <= 0 {
-			logger.LogInfo("无需删除文件")
-			return nil
-		}
+		return errors.Join(errs...)
+	})
+}
 
-		var keys []string
-		for _, k := range objects {
-			keys = append(keys, k.Key)
-		}
+// staleUploadAge 发起超过该时间仍未完成的分片上传在清理历史文件时取消
+const staleUploadAge = 7 * 24 * time.Hour
 
-		logger.LogInfo("找到 %d 个文件需要删除", len(keys))
-		deleteObjects, err := ossClient.GetSlowClient().DeleteObjects(keys)
-		if err != nil {
-			logger.LogError(err, "删除失败")
-			return err
+// cleanUploads 取消进程中断、放弃续传等留下的过期分片上传，失败只记录警告
+func (c *TaskHolder) cleanUploads(logger *utils.TaskLogger, st *storage.NamedStorage) {
+	rs, ok := st.Storage.(storage.ResumableStorage)
+	if !ok {
+		return
+	}
+
+	aborted, err := rs.CleanupUploads(time.Now().Add(-staleUploadAge))
+	if err != nil {
+		logger.LogWarning("清理未完成的上传失败: %v", err)
+	}
+	if aborted > 0 {
+		logger.LogInfo("取消 %d 个过期的未完成上传", aborted)
+	}
+}
+
+func (c *TaskHolder) cleanStorageHistory(logger *utils.TaskLogger, st *storage.NamedStorage) error {
+	if c.repo != nil {
+		return c.pruneRepository(logger, st)
+	}
+
+	// 只列出该任务的前缀，并且只删除按本任务模板生成的文件
+	objects, err := st.List(c.keyTemplate.Prefix())
+	if err != nil {
+		logger.LogError(err, "列出对象失败")
+		return err
+	}
+
+	// 同一备份的分卷按去掉分卷后缀的名称作为一个整体
+	var names []string
+	for _, object := range objects {
+		if c.keyTemplate.Match(object.Key) {
+			names = append(names, utils.TrimVolumeSuffix(path.Base(object.Key)))
 		}
+	}
+	// 未过期的增量备份依赖的全量备份即使过期也保留
+	dependent := utils.DependentFullBackups(c.ID, names)
+
+	var keys []string
+	kept := 0
+	for _, object := range objects {
+		name := path.Base(object.Key)
+		if !c.keyTemplate.Match(object.Key) || !utils.IsNeedDeleteFile(c.ID, name) {
+			continue
+		}
+		if dependent[utils.TrimVolumeSuffix(name)] {
+			kept++
+			continue
+		}
+		keys = append(keys, object.Key)
+	}
+	if kept > 0 {
+		logger.LogInfo("保留 %d 个增量备份依赖的全量备份", kept)
+	}
 
-		logger.LogInfo("成功删除：%v", deleteObjects.DeletedObjects)
+	if len(keys) <= 0 {
+		logger.LogInfo("无需删除文件")
 		return nil
-	})
+	}
+
+	logger.LogInfo("找到 %d 个文件需要删除", len(keys))
+	deletedKeys, err := st.Delete(keys)
+	if err != nil {
+		logger.LogError(err, "删除失败")
+		return err
+	}
+
+	logger.LogInfo("成功删除：%v", deletedKeys)
+	return nil
+}
+
+// pruneRepository 仓库模式按快照时间清理，删除过期快照和不再被引用的 pack
+func (c *TaskHolder) pruneRepository(logger *utils.TaskLogger, st *storage.NamedStorage) error {
+	result, err := c.repo.Prune(st, c.conf.Repository.GetKeepDays())
+	if err != nil {
+		logger.LogError(err, "清理仓库失败")
+		return err
+	}
+
+	logger.LogInfo("删除 %d 个快照，保留 %d 个快照，删除 %d 个 pack", result.Snapshots, result.Kept, result.Packs)
+	return nil
 }
 
 func (c *TaskHolder) backupWithLogger(logger *utils.TaskLogger) {
 	conf := c.conf
-	path := conf.BackPath
+	paths := conf.GetBackPaths()
 
 	logger.ExecuteStep("备份", func() error {
-		logger.LogInfo("备份路径: %s", path)
+		logger.LogInfo("备份路径: %s", strings.Join(paths, ", "))
 
 		// 执行前置命令
 		if conf.BeforeCmd != "" {
@@ -177,28 +314,11 @@ func (c *TaskHolder) backupWithLogger(logger *utils.TaskLogger) {
 			}
 		}
 
-		// 压缩文件
-		var zipFile string
-		if err := logger.ExecuteStep("压缩文件", func() error {
-			var err error
-			zipFile, err = utils.ZipPath(path, utils.GetFileName(c.ID), func(filePath string, processed, total int64, percentage float64) {
-				logger.LogProgress(filePath, processed, total, percentage)
-			}, func(total int64) {
-				logger.LogInfo("压缩完成，总大小: %s", utils.FormatBytes(total))
-			})
-			if err != nil {
-				logger.LogError(err, "压缩失败")
-				return err
+		afterCmd := func() error {
+			if conf.AfterCmd == "" {
+				return nil
 			}
-			return nil
-		}); err != nil {
-			return err
-		}
-		defer os.Remove(zipFile)
-
-		// 执行后置命令
-		if conf.AfterCmd != "" {
-			if err := logger.ExecuteStep("执行后置命令", func() error {
+			return logger.ExecuteStep("执行后置命令", func() error {
 				logger.LogInfo("命令: %s", conf.AfterCmd)
 				cmd := exec.Command("bash", "-c", conf.AfterCmd)
 				if err := cmd.Run(); err != nil {
@@ -206,38 +326,513 @@ func (c *TaskHolder) backupWithLogger(logger *utils.TaskLogger) {
 					return err
 				}
 				return nil
+			})
+		}
+
+		// 仓库模式不生成压缩包，部分目标失败时仍执行后置命令
+		if c.repo != nil {
+			repoErr := logger.ExecuteStep("备份到仓库", func() error {
+				return c.repositoryBackupWithLogger(logger, paths)
+			})
+			if repoErr != nil && !errors.Is(repoErr, utils.ErrPartialFailed) {
+				return repoErr
+			}
+			if err := afterCmd(); err != nil {
+				return err
+			}
+			return repoErr
+		}
+
+		// 先续传上次失败时保留的压缩包，续传失败不影响本次备份
+		c.resumePendingWithLogger(logger)
+
+		// 增量备份按文件清单决定本次是全量还是增量
+		opts := c.archiveOpts
+		kind := ""
+		fileName := utils.GetFileName(c.ID, c.conf.Format)
+		if conf.Incremental != nil {
+			opts.Manifest = true
+			opts.Base = c.incrementalBase(logger, paths)
+			kind = utils.BackupFull
+			if opts.Base != nil {
+				kind = utils.BackupIncremental
+			}
+			fileName = utils.GetBackupFileName(c.ID, kind, c.conf.Format)
+		}
+		if c.encryption != "" {
+			fileName += utils.EncryptedSuffix
+		}
+		objKey := c.keyTemplate.Key(filepath.Base(fileName))
+		startTime := time.Now()
+
+		// 流式模式边压缩边上传，不生成本地压缩包，部分目标失败时仍执行后置命令
+		var archive *utils.ArchiveInfo
+		var streamErr error
+		var kept bool
+		if conf.Stream {
+			streamErr = logger.ExecuteStep("压缩并上传", func() error {
+				var err error
+				if conf.SplitSize > 0 {
+					archive, err = c.volumeUploadWithLogger(logger, objKey, fileName, paths, opts)
+				} else {
+					archive, err = c.streamUploadWithLogger(logger, objKey, paths, opts)
+				}
+				return err
+			})
+			if streamErr != nil && !errors.Is(streamErr, utils.ErrPartialFailed) {
+				return streamErr
+			}
+		}
+
+		// 压缩文件
+		if !conf.Stream {
+			if err := logger.ExecuteStep("压缩文件", func() error {
+				progress := func(filePath string, processed, total int64, percentage float64) {
+					logger.LogProgress(filePath, processed, total, percentage)
+				}
+				done := func(total int64) {
+					logger.LogInfo("压缩完成，总大小: %s", utils.FormatBytes(total))
+				}
+				var err error
+				if conf.SplitSize > 0 {
+					archive, err = utils.ZipVolumes(paths, fileName, int64(conf.SplitSize), opts, nil, progress, done)
+				} else {
+					archive, err = utils.ZipPath(paths, fileName, opts, progress, done)
+				}
+				if err != nil {
+					logger.LogError(err, "压缩失败")
+					return err
+				}
+				logArchive(logger, archive)
+				return nil
 			}); err != nil {
 				return err
 			}
+			defer func() {
+				if !kept {
+					os.Remove(archive.Path)
+				}
+			}()
+			for _, volume := range archive.Volumes {
+				defer os.Remove(volume.Path)
+			}
 		}
 
-		// 上传到OSS
-		objKey := filepath.Base(zipFile)
-		ossClient := c.ossClient
-		if err := logger.ExecuteStep("上传到OSS", func() error {
-			logger.LogInfo("文件: %s", objKey)
+		// 执行后置命令
+		if err := afterCmd(); err != nil {
+			return err
+		}
 
-			err := ossClient.Upload(objKey, zipFile, func(message string) {
-				logger.LogInfo("上传进度: %s", message)
+		if conf.Stream {
+			if streamErr == nil {
+				c.saveManifest(logger, kind, objKey, paths, startTime, archive)
+			}
+			return streamErr
+		}
+
+		// 上传到存储
+		if err := logger.ExecuteStep("上传", func() error {
+			if len(archive.Volumes) > 0 {
+				return c.uploadVolumesWithLogger(logger, objKey, archive.Volumes)
+			}
+			var err error
+			kept, err = c.uploadWithLogger(logger, objKey, archive)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		c.saveManifest(logger, kind, objKey, paths, startTime, archive)
+		return nil
+	})
+}
+
+// repositoryBackupWithLogger 把备份路径写入去重仓库，生成一个新快照
+func (c *TaskHolder) repositoryBackupWithLogger(logger *utils.TaskLogger, paths []string) error {
+	result, err := c.repo.Backup(paths)
+	if err != nil {
+		logger.LogError(err, "备份到仓库失败")
+		return err
+	}
+
+	logger.LogInfo("快照: %s，文件 %d 个，块 %d 个，新增块 %d 个，上传 %s",
+		result.Snapshot, result.Files, result.Chunks, result.NewChunks, utils.FormatBytes(result.Uploaded))
+	logSkipped(logger, &utils.ArchiveInfo{Excluded: result.Excluded, Skipped: result.Skipped})
+	logChanged(logger, result.Changed)
+	return c.logUploadResults(logger, result.Snapshot, result.Errs, nil)
+}
+
+// uploadWithLogger 并行上传到所有存储目标并校验上传结果，部分目标失败时返回 utils.ErrPartialFailed；
+// 有目标失败时保留压缩包下次运行时续传，kept 为 true 时调用方不删除压缩包
+func (c *TaskHolder) uploadWithLogger(logger *utils.TaskLogger, objKey string, archive *utils.ArchiveInfo) (kept bool, err error) {
+	logger.LogInfo("文件: %s", objKey)
+	errs, verified := c.putToStorages(logger, objKey, archive, nil)
+	err = c.logUploadResults(logger, objKey, errs, verified)
+	return c.keepPending(logger, objKey, archive, errs), err
+}
+
+// putToStorages 并行上传文件到存储目标并校验，skip 中已失败的目标不再上传，返回每个目标的结果和实际校验过的项目
+func (c *TaskHolder) putToStorages(logger *utils.TaskLogger, objKey string, archive *utils.ArchiveInfo, skip []error) ([]error, []storage.Verified) {
+	opts := c.putOptions(archive)
+
+	errs := make([]error, len(c.storages))
+	verified := make([]storage.Verified, len(c.storages))
+	var wg sync.WaitGroup
+	for i, st := range c.storages {
+		if skip != nil && storage.HasError(skip[i]) {
+			errs[i] = skip[i]
+			continue
+		}
+		wg.Add(1)
+		go func(i int, st *storage.NamedStorage) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic: %v", r)
+				}
+			}()
+
+			errs[i] = st.Put(objKey, archive.Path, opts, func(message string) {
+				logger.LogInfo("【%s】上传进度: %s", st.Name, message)
 			})
+			if errs[i] == nil {
+				verified[i], errs[i] = storage.Verify(st, objKey, opts.Checksum)
+			}
+		}(i, st)
+	}
+	wg.Wait()
+
+	return errs, verified
+}
+
+// uploadVolumesWithLogger 依次上传分卷，某个目标上传失败后不再上传后续分卷
+func (c *TaskHolder) uploadVolumesWithLogger(logger *utils.TaskLogger, objKey string, volumes []*utils.ArchiveInfo) error {
+	logger.LogInfo("文件: %s，共 %d 个分卷", objKey, len(volumes))
+
+	errs := make([]error, len(c.storages))
+	verified := make([]storage.Verified, len(c.storages))
+	for _, volume := range volumes {
+		c.putVolume(logger, objKey, volume, errs, verified)
+	}
+	return c.logUploadResults(logger, objKey, errs, verified)
+}
+
+// putVolume 上传一个分卷，结果合并到 errs，每个目标只保留第一个错误；
+// verified 只保留所有分卷都校验过的项目
+func (c *TaskHolder) putVolume(logger *utils.TaskLogger, objKey string, volume *utils.ArchiveInfo, errs []error, verified []storage.Verified) {
+	key := objKey + filepath.Ext(volume.Path)
+	logger.LogInfo("上传分卷: %s，大小: %s", key, utils.FormatBytes(volume.Size))
+	volumeErrs, volumeVerified := c.putToStorages(logger, key, volume, errs)
+	for i, err := range volumeErrs {
+		if errs[i] == nil {
+			errs[i] = err
+			verified[i] = verified[i].Merge(volumeVerified[i])
+		}
+	}
+}
+
+// errAllVolumeUploadFailed 所有目标都上传分卷失败，停止压缩
+var errAllVolumeUploadFailed = errors.New("all storages upload volume failed")
+
+// volumeUploadWithLogger 分卷流式上传，每个分卷写完后立即上传并删除，本地最多保留一个分卷
+func (c *TaskHolder) volumeUploadWithLogger(logger *utils.TaskLogger, objKey, fileName string, sources []string, archiveOpts utils.ArchiveOptions) (*utils.ArchiveInfo, error) {
+	logger.LogInfo("文件: %s，分卷大小: %s", objKey, utils.FormatBytes(int64(c.conf.SplitSize)))
+
+	errs := make([]error, len(c.storages))
+	verified := make([]storage.Verified, len(c.storages))
+	archive, zipErr := utils.ZipVolumes(sources, fileName, int64(c.conf.SplitSize), archiveOpts, func(volume *utils.ArchiveInfo) error {
+		defer os.Remove(volume.Path)
+		c.putVolume(logger, objKey, volume, errs, verified)
+		for _, err := range errs {
+			if !storage.HasError(err) {
+				return nil
+			}
+		}
+		return errAllVolumeUploadFailed
+	}, func(filePath string, processed, total int64, percentage float64) {
+		logger.LogProgress(filePath, processed, total, percentage)
+	}, func(total int64) {
+		logger.LogInfo("压缩完成，总大小: %s", utils.FormatBytes(total))
+	})
+
+	// 所有目标都失败时按上传结果记录，其他压缩错误直接失败
+	if zipErr != nil && !errors.Is(zipErr, errAllVolumeUploadFailed) {
+		logger.LogError(zipErr, "压缩失败")
+		return nil, zipErr
+	}
+	if archive != nil {
+		logArchive(logger, archive)
+		logger.LogInfo("共 %d 个分卷", len(archive.Volumes))
+	}
+
+	return archive, c.logUploadResults(logger, objKey, errs, verified)
+}
+
+// streamUploadWithLogger 压缩数据通过管道同时写入所有存储目标，单个目标失败不影响其他目标
+func (c *TaskHolder) streamUploadWithLogger(logger *utils.TaskLogger, objKey string, sources []string, archiveOpts utils.ArchiveOptions) (*utils.ArchiveInfo, error) {
+	logger.LogInfo("文件: %s", objKey)
+
+	// 原始大小作为压缩包大小的上限，用于选择分片大小
+	sizeHint, err := utils.DirSize(sources, archiveOpts)
+	if err != nil {
+		logger.LogError(err, "计算目录大小失败")
+		return nil, err
+	}
+
+	// 流式上传开始时还没有校验值，完成后再校验
+	opts := c.putOptions(nil)
+	errs := make([]error, len(c.storages))
+	verified := make([]storage.Verified, len(c.storages))
+	writers := make([]io.Writer, len(c.storages))
+	pipes := make([]*io.PipeWriter, len(c.storages))
+	var wg sync.WaitGroup
+	for i, st := range c.storages {
+		ss, ok := st.Storage.(storage.StreamStorage)
+		if !ok {
+			errs[i] = fmt.Errorf("storage %s does not support stream upload", st.Name)
+			continue
+		}
+
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		wg.Add(1)
+		go func(i int, st *storage.NamedStorage) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic: %v", r)
+				}
+				// 上传提前结束时关闭读端，压缩不再等待该目标
+				pr.CloseWithError(errs[i])
+			}()
+
+			errs[i] = ss.PutStream(objKey, pr, sizeHint, opts, func(message string) {
+				logger.LogInfo("【%s】上传进度: %s", st.Name, message)
+			})
+		}(i, st)
+	}
+
+	archive, zipErr := utils.ZipToWriter(sources, utils.NewFanOutWriter(writers...), archiveOpts, func(filePath string, processed, total int64, percentage float64) {
+		logger.LogProgress(filePath, processed, total, percentage)
+	}, func(total int64) {
+		logger.LogInfo("压缩完成，总大小: %s", utils.FormatBytes(total))
+	})
+	for _, pw := range pipes {
+		if pw != nil {
+			// 压缩失败时上传方读到错误并放弃，成功时读到 EOF
+			pw.CloseWithError(zipErr)
+		}
+	}
+	wg.Wait()
+
+	// 所有目标都失败时按上传结果记录，其他压缩错误直接失败
+	if zipErr != nil && !errors.Is(zipErr, utils.ErrAllWritersFailed) {
+		logger.LogError(zipErr, "压缩失败")
+		return nil, zipErr
+	}
+
+	if archive != nil {
+		logArchive(logger, archive)
+		checksum := &storage.Checksum{Size: archive.Size, MD5: archive.MD5, CRC64: archive.CRC64}
+		for i, st := range c.storages {
+			if errs[i] == nil {
+				verified[i], errs[i] = storage.Verify(st, objKey, checksum)
+			}
+		}
+	}
+
+	return archive, c.logUploadResults(logger, objKey, errs, verified)
+}
+
+// incrementalBase 返回增量备份的基准清单，需要全量备份时返回 nil
+func (c *TaskHolder) incrementalBase(logger *utils.TaskLogger, sources []string) *utils.Manifest {
+	manifest, err := utils.LoadManifest(c.manifestPath())
+	switch {
+	case err != nil:
+		logger.LogInfo("读取文件清单失败，执行全量备份: %v", err)
+	case manifest == nil:
+		logger.LogInfo("没有文件清单，执行全量备份")
+	case !manifest.Compatible(sources, c.conf.Format):
+		logger.LogInfo("备份路径或格式已变化，执行全量备份")
+	case time.Since(manifest.Time) >= c.conf.Incremental.GetFullInterval():
+		logger.LogInfo("距上次全量备份 %s 已超过间隔，执行全量备份", manifest.Full)
+	default:
+		logger.LogInfo("增量备份，基准: %s", manifest.Full)
+		return manifest
+	}
+	return nil
+}
+
+// saveManifest 全量备份上传到所有存储后更新文件清单，之后的增量备份以它为基准；
+// 部分存储失败时不更新，下次继续全量备份
+func (c *TaskHolder) saveManifest(logger *utils.TaskLogger, kind, objKey string, sources []string, startTime time.Time, archive *utils.ArchiveInfo) {
+	if kind != utils.BackupFull || archive == nil || archive.Manifest == nil {
+		return
+	}
+
+	manifest := archive.Manifest
+	manifest.Full = objKey
+	manifest.Time = startTime
+	manifest.Sources = sources
+	manifest.Format = c.conf.Format
+	if err := manifest.Save(c.manifestPath()); err != nil {
+		logger.LogError(err, "保存文件清单失败")
+		return
+	}
+	logger.LogInfo("已更新文件清单，共 %d 个文件", len(manifest.Files))
+}
+
+func (c *TaskHolder) manifestPath() string {
+	return filepath.Join(config.Config.GetStateDir(), "manifest_"+c.ID+".json")
+}
+
+// logArchive 记录压缩包校验值、增量备份删除的文件和跳过的文件
+func logArchive(logger *utils.TaskLogger, archive *utils.ArchiveInfo) {
+	logger.LogInfo("SHA256: %s", archive.SHA256)
+	if archive.Deleted > 0 {
+		logger.LogInfo("相对全量备份删除了 %d 个文件", archive.Deleted)
+	}
+	logSkipped(logger, archive)
+	logChanged(logger, archive.Changed)
+}
+
+// maxSkippedListed 通知中最多列出的跳过文件数
+const maxSkippedListed = 20
+
+// logSkipped 记录按排除规则和文件策略跳过的文件
+func logSkipped(logger *utils.TaskLogger, archive *utils.ArchiveInfo) {
+	if archive.Excluded > 0 {
+		logger.LogInfo("按排除规则跳过 %d 个文件或目录", archive.Excluded)
+	}
+	if len(archive.Skipped) <= 0 {
+		return
+	}
+
+	logger.LogInfo("跳过 %d 个无法备份的文件", len(archive.Skipped))
+	for i, file := range archive.Skipped {
+		if i >= maxSkippedListed {
+			logger.LogInfo("  ... 其余 %d 个省略", len(archive.Skipped)-i)
+			break
+		}
+		logger.LogInfo("  %s: %s", file.Path, file.Reason)
+	}
+}
 
-			if ossClient.HasError(err) {
+// logChanged 记录读取过程中发生变化的文件，作为警告列在通知的错误部分
+func logChanged(logger *utils.TaskLogger, changed []utils.SkippedFile) {
+	if len(changed) <= 0 {
+		return
+	}
+
+	logger.LogInfo("%d 个文件在备份过程中发生变化，内容可能不一致", len(changed))
+	for i, file := range changed {
+		if i >= maxSkippedListed {
+			logger.LogWarning("其余 %d 个发生变化的文件省略", len(changed)-i)
+			break
+		}
+		logger.LogWarning("%s: %s", file.Path, file.Reason)
+	}
+}
+
+// putOptions 按任务配置生成上传选项，元数据附带任务 ID、主机名、备份路径和压缩包 SHA256
+func (c *TaskHolder) putOptions(archive *utils.ArchiveInfo) storage.PutOptions {
+	object := c.conf.Object
+	opts := storage.PutOptions{
+		StorageClass: object.StorageClass,
+		Encryption:   object.Encryption,
+		KMSKeyID:     object.KMSKeyID,
+		ACL:          object.ACL,
+		Metadata:     make(map[string]string),
+		Tags:         object.Tags,
+	}
+
+	opts.Metadata["backup-task"] = c.ID
+	if host, err := os.Hostname(); err == nil {
+		opts.Metadata["backup-host"] = host
+	}
+	opts.Metadata["backup-source"] = strings.Join(c.conf.GetBackPaths(), ",")
+	if c.encryption != "" {
+		opts.Metadata["backup-encryption"] = c.encryption
+	}
+	if archive != nil {
+		opts.Metadata["backup-sha256"] = archive.SHA256
+		opts.Checksum = &storage.Checksum{
+			Size:  archive.Size,
+			MD5:   archive.MD5,
+			CRC64: archive.CRC64,
+		}
+	}
+	for k, v := range object.Metadata {
+		opts.Metadata[k] = v
+	}
+
+	return opts
+}
+
+// logUploadResults 按存储目标记录上传结果，verified 为每个目标实际校验过的项目，为 nil 时不记录校验结果
+func (c *TaskHolder) logUploadResults(logger *utils.TaskLogger, objKey string, errs []error, verified []storage.Verified) error {
+	var failed int
+	var results []string
+	for i, st := range c.storages {
+		err := errs[i]
+		logger.ExecuteStep("上传到"+st.Name, func() error {
+			if errors.Is(err, storage.ErrChecksumMismatch) {
+				logger.LogError(err, "上传校验失败")
+				return err
+			}
+			if storage.HasError(err) {
 				logger.LogError(err, "上传失败")
 				return err
 			}
 
-			if ossClient.HasCoolDownError(err) {
+			if storage.HasCoolDownError(err) {
 				logger.LogInfo("上传因冷却期延迟: %s", objKey)
+			} else if verified != nil {
+				logger.LogInfo("上传完成: %s，%s", objKey, verifiedMessage(verified[i]))
 			} else {
 				logger.LogInfo("上传完成: %s", objKey)
 			}
 			return nil
-		}); err != nil {
-			return err
+		})
+
+		switch {
+		case storage.HasError(err):
+			failed++
+			results = append(results, st.Name+" ✗")
+		case storage.HasCoolDownError(err):
+			results = append(results, st.Name+" 延迟")
+		default:
+			results = append(results, st.Name+" ✓")
 		}
+	}
+	logger.LogInfo("上传结果: %s", strings.Join(results, ", "))
 
+	switch {
+	case failed <= 0:
 		return nil
-	})
+	case failed == len(c.storages):
+		return fmt.Errorf("all %d storages upload failed", failed)
+	default:
+		return fmt.Errorf("%w: %d/%d storages upload failed", utils.ErrPartialFailed, failed, len(c.storages))
+	}
+}
+
+// verifiedMessage 说明上传后实际做过的校验，存储端不提供 MD5、CRC64 时只比对了大小
+func verifiedMessage(v storage.Verified) string {
+	if !v.Size {
+		return "未校验"
+	}
+	checks := []string{"大小"}
+	if v.MD5 {
+		checks = append(checks, "MD5")
+	}
+	if v.CRC64 {
+		checks = append(checks, "CRC64")
+	}
+	if len(checks) == 1 {
+		return "仅校验大小，存储端不提供校验值"
+	}
+	return strings.Join(checks, "、") + " 一致"
 }
 
 // sendMessages 发送 TaskLogger 收集的所有消息
@@ -2,7 +2,16 @@ package main
 
 import (
 	"backup-go/config"
+	"backup-go/storage"
 	"backup-go/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -30,3 +39,152 @@ func Test_cleanOld(t *testing.T) {
 	})
 	th.cleanHistory()
 }
+
+func Test_verifiedMessage(t *testing.T) {
+	tests := map[storage.Verified]string{
+		{Size: true}:                         "仅校验大小，存储端不提供校验值",
+		{Size: true, MD5: true}:              "大小、MD5 一致",
+		{Size: true, MD5: true, CRC64: true}: "大小、MD5、CRC64 一致",
+	}
+	for verified, want := range tests {
+		if got := verifiedMessage(verified); got != want {
+			t.Errorf("verifiedMessage(%+v) = %q, want %q", verified, got, want)
+		}
+	}
+}
+
+func Test_streamUploadWithLogger(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte(strings.Repeat("backup content\n", 1000)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// WebDAV 服务端拒绝上传，模拟单个目标失败，不影响其他目标
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	c := &TaskHolder{
+		ID:   "app",
+		conf: config.BackupConfig{BackPath: source},
+		storages: []*storage.NamedStorage{
+			{Name: "local", Storage: storage.CreateLocalStorage(config.LocalConfig{Path: dir})},
+			{Name: "webdav", Storage: storage.CreateWebDAVStorage(config.WebDAVConfig{URL: server.URL})},
+		},
+	}
+	logger := utils.NewTaskLogger(c.ID)
+	archive, err := c.streamUploadWithLogger(logger, "app_2024_01_01.zip", []string{source}, utils.ArchiveOptions{})
+	if !errors.Is(err, utils.ErrPartialFailed) {
+		t.Fatalf("err = %v, want partial failed", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "app_2024_01_01.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if archive == nil || hex.EncodeToString(sum[:]) != archive.SHA256 {
+		t.Fatalf("uploaded archive does not match, archive = %+v", archive)
+	}
+
+	var verified bool
+	for _, entry := range logger.GetEntries() {
+		if strings.HasPrefix(entry.Message, "上传完成: app_2024_01_01.zip，") && strings.Contains(entry.Message, "大小") {
+			verified = true
+		}
+	}
+	if !verified {
+		t.Error("verify result should be logged")
+	}
+}
+
+// failingStorage fail 为 true 时上传失败，模拟暂时不可用的存储目标
+type failingStorage struct {
+	storage.Storage
+	fail bool
+}
+
+func (fs *failingStorage) Put(objKey, filePath string, opts storage.PutOptions, noticeFunc storage.UploadNoticeFunc) error {
+	if fs.fail {
+		return errors.New("storage unavailable")
+	}
+	return fs.Storage.Put(objKey, filePath, opts, noticeFunc)
+}
+
+func Test_resumePending(t *testing.T) {
+	stateDir := config.Config.StateDir
+	config.Config.StateDir = t.TempDir()
+	defer func() { config.Config.StateDir = stateDir }()
+
+	source := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), []byte(strings.Repeat("backup content\n", 1000)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	noop := func(string, int64, int64, float64) {}
+	zip := func(name string) *utils.ArchiveInfo {
+		t.Helper()
+		archive, err := utils.ZipPath([]string{source}, filepath.Join(t.TempDir(), name), utils.ArchiveOptions{}, noop, func(int64) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return archive
+	}
+
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	remote := &failingStorage{Storage: storage.CreateLocalStorage(config.LocalConfig{Path: remoteDir}), fail: true}
+	c := &TaskHolder{
+		ID:   "app",
+		conf: config.BackupConfig{BackPath: source},
+		storages: []*storage.NamedStorage{
+			{Name: "local", Storage: storage.CreateLocalStorage(config.LocalConfig{Path: localDir})},
+			{Name: "remote", Storage: remote},
+		},
+	}
+	logger := utils.NewTaskLogger(c.ID)
+
+	// 有目标失败时保留压缩包，再次失败时放弃之前保留的压缩包
+	first := zip("app_2024_01_01.zip")
+	if kept, err := c.uploadWithLogger(logger, "app_2024_01_01.zip", first); !kept || !errors.Is(err, utils.ErrPartialFailed) {
+		t.Fatalf("kept = %v, err = %v", kept, err)
+	}
+	second := zip("app_2024_01_02.zip")
+	if kept, _ := c.uploadWithLogger(logger, "app_2024_01_02.zip", second); !kept {
+		t.Fatal("archive should be kept when upload fails")
+	}
+	if _, err := os.Stat(first.Path); !os.IsNotExist(err) {
+		t.Fatalf("abandoned archive should be removed, err = %v", err)
+	}
+
+	// 续传只上传到失败的目标，完成后删除压缩包和记录
+	if err := os.Remove(filepath.Join(localDir, "app_2024_01_02.zip")); err != nil {
+		t.Fatal(err)
+	}
+	remote.fail = false
+	if err := c.resumePendingWithLogger(logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "app_2024_01_02.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "app_2024_01_02.zip")); !os.IsNotExist(err) {
+		t.Fatalf("storage that succeeded should not be uploaded again, err = %v", err)
+	}
+	if _, err := os.Stat(second.Path); !os.IsNotExist(err) {
+		t.Fatalf("resumed archive should be removed, err = %v", err)
+	}
+	if _, err := os.Stat(c.pendingPath()); !os.IsNotExist(err) {
+		t.Fatalf("pending record should be removed, err = %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"backup-go/config"
+	"backup-go/storage"
+	"backup-go/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// errNotPending 续传时跳过不需要续传的存储目标
+var errNotPending = errors.New("not pending")
+
+// pendingUpload 上传失败后保留的压缩包，下次运行时先续传到失败的存储目标，
+// 支持断点续传的存储从上次完成的分片继续
+type pendingUpload struct {
+	ObjKey   string   `json:"obj_key"`
+	Path     string   `json:"path"`
+	Size     int64    `json:"size"`
+	MD5      string   `json:"md5"`
+	SHA256   string   `json:"sha256"`
+	CRC64    uint64   `json:"crc64"`
+	Storages []string `json:"storages"`
+}
+
+func (p *pendingUpload) archive() *utils.ArchiveInfo {
+	return &utils.ArchiveInfo{Path: p.Path, Size: p.Size, MD5: p.MD5, SHA256: p.SHA256, CRC64: p.CRC64}
+}
+
+func (c *TaskHolder) pendingPath() string {
+	return filepath.Join(config.Config.GetStateDir(), "pending_"+c.ID+".json")
+}
+
+// loadPending 读取待续传记录，没有时返回 nil
+func (c *TaskHolder) loadPending() (*pendingUpload, error) {
+	data, err := os.ReadFile(c.pendingPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p pendingUpload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse pending upload failed: %w", err)
+	}
+	return &p, nil
+}
+
+func (c *TaskHolder) savePending(p *pendingUpload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	path := c.pendingPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// keepPending 有目标上传失败时保留压缩包并记录，下次运行时续传，返回是否保留；
+// 之前保留的其他压缩包放弃续传，本地最多多保留一个压缩包
+func (c *TaskHolder) keepPending(logger *utils.TaskLogger, objKey string, archive *utils.ArchiveInfo, errs []error) bool {
+	var failed []string
+	for i, st := range c.storages {
+		if storage.HasError(errs[i]) {
+			failed = append(failed, st.Name)
+		}
+	}
+	if len(failed) <= 0 {
+		return false
+	}
+
+	path, err := filepath.Abs(archive.Path)
+	if err != nil {
+		logger.LogError(err, "保留压缩包失败")
+		return false
+	}
+	p := &pendingUpload{
+		ObjKey:   objKey,
+		Path:     path,
+		Size:     archive.Size,
+		MD5:      archive.MD5,
+		SHA256:   archive.SHA256,
+		CRC64:    archive.CRC64,
+		Storages: failed,
+	}
+
+	old, err := c.loadPending()
+	if err != nil {
+		logger.LogWarning("读取待续传记录失败: %v", err)
+	}
+	if old != nil && old.Path != p.Path {
+		c.abandonPending(logger, old)
+	}
+
+	if err := c.savePending(p); err != nil {
+		logger.LogError(err, "保存待续传记录失败")
+		c.abandonPending(logger, p)
+		return false
+	}
+	logger.LogInfo("保留压缩包 %s，下次运行时续传到: %s", path, strings.Join(failed, ", "))
+	return true
+}
+
+// abandonPending 放弃续传，取消存储端未完成的上传，删除保留的压缩包和记录
+func (c *TaskHolder) abandonPending(logger *utils.TaskLogger, p *pendingUpload) {
+	for _, st := range c.storages {
+		rs, ok := st.Storage.(storage.ResumableStorage)
+		if !ok || !slices.Contains(p.Storages, st.Name) {
+			continue
+		}
+		if err := rs.AbortUpload(p.ObjKey); err != nil {
+			logger.LogWarning("【%s】取消未完成的上传失败: %v", st.Name, err)
+		}
+	}
+
+	os.Remove(p.Path)
+	os.Remove(c.pendingPath())
+	logger.LogInfo("放弃续传: %s", p.ObjKey)
+}
+
+// resumePendingWithLogger 续传上次失败时保留的压缩包，只上传到当时失败的目标，全部完成后删除压缩包和记录
+func (c *TaskHolder) resumePendingWithLogger(logger *utils.TaskLogger) error {
+	p, err := c.loadPending()
+	if err != nil {
+		logger.LogError(err, "读取待续传记录失败")
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+
+	return logger.ExecuteStep("续传上次失败的上传", func() error {
+		logger.LogInfo("文件: %s", p.ObjKey)
+		if _, err := os.Stat(p.Path); err != nil {
+			logger.LogError(err, "保留的压缩包不可用")
+			c.abandonPending(logger, p)
+			return err
+		}
+
+		// 配置中已删除的存储目标不再续传
+		skip := make([]error, len(c.storages))
+		targets := 0
+		for i, st := range c.storages {
+			if slices.Contains(p.Storages, st.Name) {
+				targets++
+			} else {
+				skip[i] = errNotPending
+			}
+		}
+		if targets <= 0 {
+			c.abandonPending(logger, p)
+			return nil
+		}
+
+		errs, verified := c.putToStorages(logger, p.ObjKey, p.archive(), skip)
+		var failed []string
+		for i, st := range c.storages {
+			switch {
+			case skip[i] != nil:
+			case storage.HasError(errs[i]):
+				failed = append(failed, st.Name)
+				logger.LogError(errs[i], "【%s】续传失败", st.Name)
+			case storage.HasCoolDownError(errs[i]):
+				logger.LogInfo("【%s】续传因冷却期延迟: %s", st.Name, p.ObjKey)
+			default:
+				logger.LogInfo("【%s】续传完成: %s，%s", st.Name, p.ObjKey, verifiedMessage(verified[i]))
+			}
+		}
+
+		if len(failed) > 0 {
+			p.Storages = failed
+			if err := c.savePending(p); err != nil {
+				logger.LogError(err, "保存待续传记录失败")
+			}
+			return fmt.Errorf("resume upload to %s failed", strings.Join(failed, ", "))
+		}
+
+		os.Remove(p.Path)
+		os.Remove(c.pendingPath())
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"io"
+)
+
+// 内容定义切块的大小范围，切点只取决于附近的内容，文件中间插入数据只影响附近的块
+const (
+	minChunkSize = 512 * 1024
+	avgChunkSize = 1024 * 1024
+	maxChunkSize = 8 * 1024 * 1024
+)
+
+// chunkMask 取 gear hash 的高位判断切点，高位受最近 64 字节影响
+const chunkMask = uint64(avgChunkSize-1) << (64 - 20)
+
+// gearTable 固定的随机表，修改后已有仓库无法复用之前的块
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64
+	seed := uint64(0x6261636b75702d67)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker 按内容把数据切成 minChunkSize 到 maxChunkSize 之间的块
+type chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, maxChunkSize)}
+}
+
+// next 返回下一个块，数据只在下次调用前有效，读完时返回 io.EOF
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < maxChunkSize && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	size := cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+size]
+	c.start += size
+	return chunk, nil
+}
+
+// cutPoint 返回第一个块的长度
+func cutPoint(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+
+	n := min(len(data), maxChunkSize)
+	var h uint64
+	for i := minChunkSize; i < n; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func chunkAll(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 24*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := chunkAll(t, data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not reassemble to input")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > maxChunkSize || (len(chunk) < minChunkSize && i < len(chunks)-1) {
+			t.Errorf("chunk %d size %d out of range", i, len(chunk))
+		}
+	}
+
+	// 开头插入数据后，后面的块保持不变
+	shifted := chunkAll(t, append([]byte("inserted"), data...))
+	same := make(map[string]bool)
+	for _, chunk := range chunks {
+		same[string(chunk)] = true
+	}
+	reused := 0
+	for _, chunk := range shifted {
+		if same[string(chunk)] {
+			reused++
+		}
+	}
+	if reused < len(chunks)-2 {
+		t.Errorf("reused %d of %d chunks after insert", reused, len(chunks))
+	}
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"backup-go/storage"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// PruneResult 清理结果
+type PruneResult struct {
+	// Snapshots 删除的快照数
+	Snapshots int
+	// Kept 保留的快照数
+	Kept int
+	// Packs 删除的 pack 数
+	Packs int
+}
+
+// Prune 删除超过 keepDays 天的快照，最新的快照总是保留；之后删除不再被任何快照引用的 pack，
+// 并把索引合并为一个。只读取快照和索引，加密的仓库也不需要私钥；因此不重新打包，
+// 部分块仍被引用的 pack 整个保留，其中不再引用的块（如文件变化重新读取前写入的块）占用的空间暂不回收
+func (r *Repository) Prune(st *storage.NamedStorage, keepDays int) (*PruneResult, error) {
+	keys, err := r.snapshotKeys(st)
+	if err != nil {
+		return nil, err
+	}
+
+	now := r.now().UTC()
+	cutoff := now.AddDate(0, 0, -keepDays)
+	result := &PruneResult{}
+	var removeKeys []string
+	used := make(map[string]bool)
+	for i, key := range keys {
+		t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(path.Base(key), ".json"))
+		if err == nil && t.Before(cutoff) && i < len(keys)-1 {
+			removeKeys = append(removeKeys, key, r.root+treeDir+path.Base(key))
+			result.Snapshots++
+			continue
+		}
+
+		var snapshot Snapshot
+		if err := r.readJSON(st, key, &snapshot); err != nil {
+			return nil, err
+		}
+		for _, id := range snapshot.Chunks {
+			used[id] = true
+		}
+		result.Kept++
+	}
+
+	index, indexKeys, err := r.loadIndex(st)
+	if err != nil {
+		return nil, err
+	}
+
+	// 包含任意一个仍被引用的块的 pack 整个保留
+	var kept indexFile
+	indexed := make(map[string]bool)
+	for _, p := range index.Packs {
+		if indexed[p.Pack] {
+			continue
+		}
+		indexed[p.Pack] = true
+		for _, b := range p.Blobs {
+			if used[b.ID] {
+				kept.Packs = append(kept.Packs, p)
+				break
+			}
+		}
+	}
+	keptPacks := make(map[string]bool)
+	for _, p := range kept.Packs {
+		keptPacks[p.Pack] = true
+	}
+
+	// 没有索引的 pack 来自中断的备份，一并删除
+	packs, err := st.List(r.root + packDir)
+	if err != nil {
+		return nil, err
+	}
+	var packKeys []string
+	for _, object := range packs {
+		if !keptPacks[path.Base(object.Key)] {
+			packKeys = append(packKeys, object.Key)
+		}
+	}
+	result.Packs = len(packKeys)
+
+	// 先删除快照，再写入新索引，最后删除旧索引和 pack，任何一步中断都不会留下引用不存在的块的快照
+	if err := deleteKeys(st, removeKeys); err != nil {
+		return nil, err
+	}
+	if len(packKeys) > 0 || len(indexKeys) > 1 {
+		if len(kept.Packs) > 0 {
+			newKey := r.root + indexDir + now.Format(snapshotTimeFormat) + "-prune.json"
+			if err := putJSON(st, newKey, kept); err != nil {
+				return nil, err
+			}
+			for i, key := range indexKeys {
+				if key == newKey {
+					indexKeys = append(indexKeys[:i], indexKeys[i+1:]...)
+					break
+				}
+			}
+		}
+		if err := deleteKeys(st, indexKeys); err != nil {
+			return nil, err
+		}
+	}
+	if err := deleteKeys(st, packKeys); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func putJSON(st *storage.NamedStorage, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file, err := writeTemp(data, nil)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+	return putFile(st, key, file)
+}
+
+func deleteKeys(st storage.Storage, keys []string) error {
+	if len(keys) <= 0 {
+		return nil
+	}
+	deleted, err := st.Delete(keys)
+	if err != nil {
+		return err
+	}
+	if len(deleted) != len(keys) {
+		return fmt.Errorf("delete %d of %d objects", len(deleted), len(keys))
+	}
+	return nil
+}
@@ -0,0 +1,773 @@
+package repository
+
+import (
+	"backup-go/storage"
+	"backup-go/utils"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 仓库中的对象目录：packs 保存压缩、加密后的块，index 记录块在 pack 中的位置，
+// trees 保存每次备份的文件树，snapshots 记录快照引用的块，清理时只需要读取 snapshots 和 index
+const (
+	packDir     = "packs/"
+	indexDir    = "index/"
+	treeDir     = "trees/"
+	snapshotDir = "snapshots/"
+)
+
+// keyObject 加密的仓库中计算块 ID 的密钥，加密保存；keyIDObject 为密钥指纹，明文保存，
+// 没有私钥的备份主机据此确认本地密钥与仓库中的一致
+const (
+	keyObject   = "key"
+	keyIDObject = "key.id"
+)
+
+// snapshotTimeFormat 快照名中的时间，按字典序即按时间排序
+const snapshotTimeFormat = "20060102-150405"
+
+// DefaultPackSize 默认 pack 大小
+const DefaultPackSize = 16 * 1024 * 1024
+
+// ageMagic age 加密数据的开头，读取对象时据此判断是否需要解密
+const ageMagic = "age-encryption.org/"
+
+// 文件树中的节点类型
+const (
+	NodeDir     = "dir"
+	NodeFile    = "file"
+	NodeSymlink = "symlink"
+)
+
+// Node 文件树中的一个目录、文件或符号链接
+type Node struct {
+	// Name 与压缩包中的路径一致，如 app/conf/app.conf
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	// Chunks 文件内容按顺序引用的块 ID
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// Tree 一次备份的文件树，配置加密时加密存储
+type Tree struct {
+	Sources []string `json:"sources"`
+	Nodes   []Node   `json:"nodes"`
+}
+
+// Snapshot 快照，不加密，只包含时间、主机和引用的块，清理时不需要解密；
+// 加密的仓库中块 ID 是带密钥的 HMAC，不能据此判断仓库中是否有某个已知内容
+type Snapshot struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host"`
+	Tree   string    `json:"tree"`
+	Chunks []string  `json:"chunks"`
+}
+
+// packIndex 一个 pack 中各个块的位置，不加密。块 ID 和 pack 名未加密时为明文的 SHA256，
+// 加密时为以仓库密钥计算的 HMAC-SHA256，块的压缩后大小仍然可见
+type packIndex struct {
+	Pack  string      `json:"pack"`
+	Blobs []blobEntry `json:"blobs"`
+}
+
+type blobEntry struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+type indexFile struct {
+	Packs []packIndex `json:"packs"`
+}
+
+// DecryptFunc 解密读取的对象
+type DecryptFunc func(r io.Reader) (io.Reader, error)
+
+// Options 仓库选项
+type Options struct {
+	// PackSize 默认 DefaultPackSize
+	PackSize int64
+	// Encrypt 不为空时加密 pack 和文件树
+	Encrypt utils.EncryptFunc
+	// Decrypt 恢复加密的仓库时使用，备份时本地没有仓库密钥也需要用它读取仓库中的密钥
+	Decrypt DecryptFunc
+	// Archive 排除规则和文件处理策略，与压缩包模式一致
+	Archive utils.ArchiveOptions
+	// CachePath 本地保存上一次的文件树，大小和修改时间未变的文件直接复用块，不再读取
+	CachePath string
+	// KeyPath 本地保存的仓库密钥，加密时使用，备份时不需要私钥
+	KeyPath string
+}
+
+// Repository 内容寻址的去重仓库，文件按内容切块，相同的块在仓库中只存一份；
+// 每个存储中是一个独立的仓库，备份时同时写入所有存储
+type Repository struct {
+	root     string
+	storages []*storage.NamedStorage
+	opts     Options
+	now      func() time.Time
+}
+
+// New 创建仓库，root 为仓库在存储中的目录，以 / 结尾
+func New(root string, storages []*storage.NamedStorage, opts Options) *Repository {
+	if opts.PackSize <= 0 {
+		opts.PackSize = DefaultPackSize
+	}
+	return &Repository{root: root, storages: storages, opts: opts, now: time.Now}
+}
+
+// BackupResult 一次备份的结果
+type BackupResult struct {
+	// Snapshot 快照名
+	Snapshot  string
+	Files     int
+	Chunks    int
+	NewChunks int
+	// Uploaded 上传的 pack 大小
+	Uploaded int64
+	Excluded int
+	Skipped  []utils.SkippedFile
+	// Changed 读取过程中发生变化的文件
+	Changed []utils.SkippedFile
+	// Errs 与存储一一对应，nil 表示该存储备份成功
+	Errs []error
+}
+
+// backupRun 一次备份过程中的状态
+type backupRun struct {
+	repo    *Repository
+	result  *BackupResult
+	key     []byte
+	known   map[string]bool
+	parent  map[string]Node
+	encoder *zstd.Encoder
+	pack    bytes.Buffer
+	blobs   []blobEntry
+	index   indexFile
+}
+
+// Backup 备份源路径并生成快照，读取源文件失败时返回错误，单个存储失败记录在 BackupResult.Errs 中
+func (r *Repository) Backup(sources []string) (*BackupResult, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+
+	stamp := r.now().UTC()
+	name := stamp.Format(snapshotTimeFormat)
+	run := &backupRun{
+		repo:    r,
+		result:  &BackupResult{Snapshot: name, Errs: make([]error, len(r.storages))},
+		encoder: encoder,
+	}
+
+	// 只有所有存储中都有的块才能跳过上传
+	for i, st := range r.storages {
+		index, _, err := r.loadIndex(st)
+		if err != nil {
+			run.result.Errs[i] = fmt.Errorf("load index failed: %w", err)
+			continue
+		}
+		chunks := make(map[string]bool)
+		for _, p := range index.Packs {
+			for _, b := range p.Blobs {
+				if run.known == nil || run.known[b.ID] {
+					chunks[b.ID] = true
+				}
+			}
+		}
+		run.known = chunks
+	}
+	if err := run.checkAlive(); err != nil {
+		return run.result, err
+	}
+	if r.opts.Encrypt != nil {
+		if run.key, err = run.loadKey(); err != nil {
+			return run.result, err
+		}
+	}
+
+	run.parent = r.loadCache()
+
+	tree := &Tree{Sources: sources}
+	excluded, skipped, err := utils.WalkSources(sources, r.opts.Archive, func(path, name string, info os.FileInfo) error {
+		node, err := run.addNode(path, name, info)
+		if err != nil {
+			return err
+		}
+		tree.Nodes = append(tree.Nodes, node)
+		return nil
+	})
+	run.result.Excluded = excluded
+	run.result.Skipped = skipped
+	if err != nil {
+		return run.result, err
+	}
+	if err := run.flushPack(); err != nil {
+		return run.result, err
+	}
+
+	// 依次写入索引、文件树和快照，中断时不会留下引用不存在的块的快照
+	if len(run.index.Packs) > 0 {
+		if err := run.putJSON(r.root+indexDir+name+".json", run.index, false); err != nil {
+			return run.result, err
+		}
+	}
+	treeKey := r.root + treeDir + name + ".json"
+	if err := run.putJSON(treeKey, tree, r.opts.Encrypt != nil); err != nil {
+		return run.result, err
+	}
+
+	refs := make(map[string]bool)
+	for _, node := range tree.Nodes {
+		for _, id := range node.Chunks {
+			refs[id] = true
+		}
+	}
+	snapshot := Snapshot{Time: stamp, Tree: treeKey, Chunks: make([]string, 0, len(refs))}
+	snapshot.Host, _ = os.Hostname()
+	for id := range refs {
+		snapshot.Chunks = append(snapshot.Chunks, id)
+	}
+	sort.Strings(snapshot.Chunks)
+	run.result.Chunks = len(refs)
+	if err := run.putJSON(r.root+snapshotDir+name+".json", snapshot, false); err != nil {
+		return run.result, err
+	}
+
+	r.saveCache(tree)
+	return run.result, nil
+}
+
+func (run *backupRun) addNode(path, name string, info os.FileInfo) (Node, error) {
+	node := Node{Name: name, Mode: info.Mode(), ModTime: info.ModTime()}
+	switch {
+	case info.IsDir():
+		node.Type = NodeDir
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return node, utils.NewUnreadableError(err)
+		}
+		node.Type = NodeSymlink
+		node.Link = link
+	default:
+		node.Type = NodeFile
+		node.Size = info.Size()
+		run.result.Files++
+
+		if parent, ok := run.parent[name]; ok && parent.Size == node.Size && parent.ModTime.Equal(node.ModTime) && run.allKnown(parent.Chunks) {
+			node.Chunks = parent.Chunks
+			return node, nil
+		}
+
+		chunks, stat, changed, err := run.addFile(path)
+		if err != nil {
+			return node, err
+		}
+		node.Size, node.ModTime = stat.Size(), stat.ModTime()
+		if changed != "" {
+			if run.repo.opts.Archive.Changed == utils.PolicyFail {
+				return node, utils.ChangedError(path, changed)
+			}
+			run.result.Changed = append(run.result.Changed, utils.SkippedFile{Path: path, Reason: changed})
+		}
+		node.Chunks = chunks
+	}
+	return node, nil
+}
+
+func (run *backupRun) allKnown(chunks []string) bool {
+	for _, id := range chunks {
+		if !run.known[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// addFile 切块并写入文件内容，读取前后文件发生变化时按 Archive.Changed 重新读取，
+// 返回快照中记录的文件信息和变化说明；没有变化时为读取后的信息，仍然变化时为读取前的信息，下次备份会重新读取
+func (run *backupRun) addFile(path string) ([]string, os.FileInfo, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, "", utils.NewUnreadableError(err)
+	}
+	defer f.Close()
+
+	for attempt := 0; ; attempt++ {
+		before, err := f.Stat()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		chunks, err := run.addChunks(f, path)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		after, err := f.Stat()
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		// 重新读取前写入的块不被快照引用，但清理不重新打包，所在 pack 中还有被引用的块时不会删除，
+		// 直到整个 pack 不再被引用才回收空间
+		changed := utils.FileChanged(before, after)
+		if changed != "" && !utils.RetryChanged(run.repo.opts.Archive.Changed, attempt) {
+			return chunks, before, changed, nil
+		}
+		if changed == "" {
+			return chunks, after, "", nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, "", err
+		}
+	}
+}
+
+func (run *backupRun) addChunks(r io.Reader, path string) ([]string, error) {
+	var chunks []string
+	c := newChunker(r)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s failed: %w", path, err)
+		}
+
+		id, err := run.addChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, id)
+	}
+}
+
+// addChunk 新的块压缩后写入当前 pack，pack 写满时上传
+func (run *backupRun) addChunk(data []byte) (string, error) {
+	id := objectID(run.key, data)
+	if run.known[id] {
+		return id, nil
+	}
+
+	compressed := run.encoder.EncodeAll(data, nil)
+	run.blobs = append(run.blobs, blobEntry{ID: id, Offset: int64(run.pack.Len()), Length: int64(len(compressed))})
+	run.pack.Write(compressed)
+	run.known[id] = true
+	run.result.NewChunks++
+
+	if int64(run.pack.Len()) >= run.repo.opts.PackSize {
+		return id, run.flushPack()
+	}
+	return id, nil
+}
+
+func (run *backupRun) flushPack() error {
+	if run.pack.Len() <= 0 {
+		return nil
+	}
+
+	name := objectID(run.key, run.pack.Bytes())
+	if err := run.put(run.repo.root+packDir+name, run.pack.Bytes(), run.repo.opts.Encrypt != nil); err != nil {
+		return err
+	}
+
+	run.result.Uploaded += int64(run.pack.Len())
+	run.index.Packs = append(run.index.Packs, packIndex{Pack: name, Blobs: run.blobs})
+	run.pack.Reset()
+	run.blobs = nil
+	return nil
+}
+
+// objectID 块 ID 和 pack 名，key 为空时为内容的 SHA256，否则为 HMAC-SHA256
+func objectID(key, data []byte) string {
+	if key == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyID 密钥指纹，不泄露密钥
+func keyID(key []byte) string {
+	return objectID(key, []byte("backup-go repository key"))
+}
+
+// loadKey 返回加密仓库的密钥，优先读取本地保存的密钥；本地没有时读取仓库中的密钥，需要 Decrypt；
+// 仓库中也没有时生成新的密钥。密钥保存到本地，并加密上传到还没有密钥的存储。
+// 使用的密钥与已有密钥的存储中的指纹不一致时失败，否则块 ID 不同，去重失效，用仓库密钥也无法恢复
+func (run *backupRun) loadKey() ([]byte, error) {
+	r := run.repo
+	key, err := r.readLocalKey()
+	if err != nil {
+		return nil, err
+	}
+	local := key != nil
+	source := r.opts.KeyPath
+
+	missing := make([]bool, len(r.storages))
+	for i, st := range r.storages {
+		if run.result.Errs[i] != nil {
+			continue
+		}
+		exists, err := r.hasKey(st)
+		if err != nil {
+			run.result.Errs[i] = fmt.Errorf("load key failed: %w", err)
+			continue
+		}
+		if !exists {
+			missing[i] = true
+			continue
+		}
+		if key != nil {
+			continue
+		}
+		if r.opts.Decrypt == nil {
+			return nil, fmt.Errorf("repository key not found in %s, copy it from the previous host or configure identity_file", r.opts.KeyPath)
+		}
+		if key, err = r.readKey(st); err != nil {
+			run.result.Errs[i] = fmt.Errorf("load key failed: %w", err)
+		}
+		source = st.Name
+	}
+	if err := run.checkAlive(); err != nil {
+		return nil, err
+	}
+	for i, st := range r.storages {
+		if missing[i] || run.result.Errs[i] != nil || key == nil {
+			continue
+		}
+		id, err := r.readObject(st, r.root+keyIDObject)
+		if err != nil {
+			run.result.Errs[i] = fmt.Errorf("load key id failed: %w", err)
+			continue
+		}
+		if strings.TrimSpace(string(id)) != keyID(key) {
+			return nil, fmt.Errorf("repository key in %s does not match the key from %s", st.Name, source)
+		}
+	}
+	if err := run.checkAlive(); err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	if !local {
+		if err := r.saveLocalKey(key); err != nil {
+			return nil, fmt.Errorf("save repository key failed: %w", err)
+		}
+	}
+
+	// 先上传指纹，有密钥的存储一定有指纹
+	idFile, err := writeTemp([]byte(keyID(key)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(idFile)
+	file, err := writeTemp([]byte(hex.EncodeToString(key)), r.opts.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file)
+	for i, st := range r.storages {
+		if missing[i] && run.result.Errs[i] == nil {
+			run.result.Errs[i] = putFile(st, r.root+keyIDObject, idFile)
+		}
+		if missing[i] && run.result.Errs[i] == nil {
+			run.result.Errs[i] = putFile(st, r.root+keyObject, file)
+		}
+	}
+	return key, run.checkAlive()
+}
+
+func (r *Repository) hasKey(st storage.Storage) (bool, error) {
+	objects, err := st.List(r.root + keyObject)
+	if err != nil {
+		return false, err
+	}
+	for _, object := range objects {
+		if object.Key == r.root+keyObject {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readKey 读取并解密仓库中的密钥
+func (r *Repository) readKey(st storage.Storage) ([]byte, error) {
+	data, err := r.readObject(st, r.root+keyObject)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(data)
+}
+
+func (r *Repository) readLocalKey() ([]byte, error) {
+	if r.opts.KeyPath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(r.opts.KeyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(data)
+}
+
+func (r *Repository) saveLocalKey(key []byte) error {
+	if r.opts.KeyPath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.opts.KeyPath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(r.opts.KeyPath, []byte(hex.EncodeToString(key)), 0600)
+}
+
+func decodeKey(data []byte) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid repository key")
+	}
+	return key, nil
+}
+
+func (run *backupRun) putJSON(key string, v any, encrypt bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return run.put(key, data, encrypt)
+}
+
+// put 上传到所有未失败的存储并校验，所有存储都失败时返回错误
+func (run *backupRun) put(key string, data []byte, encrypt bool) error {
+	var encryptFunc utils.EncryptFunc
+	if encrypt {
+		encryptFunc = run.repo.opts.Encrypt
+	}
+	file, err := writeTemp(data, encryptFunc)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+
+	for i, st := range run.repo.storages {
+		if run.result.Errs[i] == nil {
+			run.result.Errs[i] = putFile(st, key, file)
+		}
+	}
+	return run.checkAlive()
+}
+
+func (run *backupRun) checkAlive() error {
+	for _, err := range run.result.Errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("all storages failed: %w", errors.Join(run.result.Errs...))
+}
+
+func writeTemp(data []byte, encrypt utils.EncryptFunc) (string, error) {
+	f, err := os.CreateTemp("", "backup-go-repo-*")
+	if err != nil {
+		return "", err
+	}
+
+	if encrypt != nil {
+		var ew io.WriteCloser
+		if ew, err = encrypt(f); err == nil {
+			if _, err = ew.Write(data); err == nil {
+				err = ew.Close()
+			}
+		}
+	} else {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func putFile(st *storage.NamedStorage, key, file string) error {
+	sum, err := utils.ChecksumFile(file)
+	if err != nil {
+		return err
+	}
+	checksum := &storage.Checksum{Size: sum.Size, MD5: sum.MD5, CRC64: sum.CRC64}
+	if err := st.Put(key, file, storage.PutOptions{Checksum: checksum}, func(string) {}); err != nil {
+		return fmt.Errorf("upload %s failed: %w", key, err)
+	}
+	if _, err := storage.Verify(st, key, checksum); err != nil {
+		return fmt.Errorf("upload %s failed: %w", key, err)
+	}
+	return nil
+}
+
+// readObject 下载对象，加密的对象解密后返回
+func (r *Repository) readObject(st storage.Storage, key string) ([]byte, error) {
+	f, err := os.CreateTemp("", "backup-go-repo-*")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := st.Get(key, f.Name()); err != nil {
+		return nil, fmt.Errorf("download %s failed: %w", key, err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(ageMagic)) {
+		return data, nil
+	}
+
+	if r.opts.Decrypt == nil {
+		return nil, fmt.Errorf("%s is encrypted, identity or passphrase required", key)
+	}
+	reader, err := r.opts.Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func (r *Repository) readJSON(st storage.Storage, key string, v any) error {
+	data, err := r.readObject(st, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s failed: %w", key, err)
+	}
+	return nil
+}
+
+// loadIndex 读取并合并存储中的所有索引
+func (r *Repository) loadIndex(st storage.Storage) (*indexFile, []string, error) {
+	objects, err := st.List(r.root + indexDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var merged indexFile
+	var keys []string
+	for _, object := range objects {
+		var index indexFile
+		if err := r.readJSON(st, object.Key, &index); err != nil {
+			return nil, nil, err
+		}
+		merged.Packs = append(merged.Packs, index.Packs...)
+		keys = append(keys, object.Key)
+	}
+	return &merged, keys, nil
+}
+
+// snapshotKeys 返回按时间排序的快照 key
+func (r *Repository) snapshotKeys(st storage.Storage) ([]string, error) {
+	objects, err := st.List(r.root + snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, ".json") {
+			keys = append(keys, object.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Snapshots 返回存储中按时间排序的快照名
+func (r *Repository) Snapshots(st storage.Storage) ([]string, error) {
+	keys, err := r.snapshotKeys(st)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimSuffix(path.Base(key), ".json"))
+	}
+	return names, nil
+}
+
+func (r *Repository) loadCache() map[string]Node {
+	nodes := make(map[string]Node)
+	if r.opts.CachePath == "" {
+		return nodes
+	}
+
+	data, err := os.ReadFile(r.opts.CachePath)
+	if err != nil {
+		return nodes
+	}
+	var tree Tree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nodes
+	}
+	for _, node := range tree.Nodes {
+		if node.Type == NodeFile {
+			nodes[node.Name] = node
+		}
+	}
+	return nodes
+}
+
+// saveCache 缓存只用于加速，写入失败时下次备份重新读取所有文件
+func (r *Repository) saveCache(tree *Tree) {
+	if r.opts.CachePath == "" {
+		return
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.opts.CachePath), 0755); err != nil {
+		return
+	}
+	tmp := r.opts.CachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err == nil {
+		os.Rename(tmp, r.opts.CachePath)
+	}
+}
@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"backup-go/config"
+	"backup-go/storage"
+	"backup-go/utils"
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestRepository(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 6*1024*1024)
+	rand.New(rand.NewSource(1)).Read(big)
+	files := map[string][]byte{
+		"big.bin":       big,
+		"sub/small.txt": []byte("small"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(source, filepath.FromSlash(name)), data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/small.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// old.bin 只在第一个快照中，清理后它的 pack 被删除
+	old := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(2)).Read(old)
+	if err := os.WriteFile(filepath.Join(source, "old.bin"), old, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypt, _, err := utils.NewEncryptor([]string{identity.Recipient().String()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &storage.NamedStorage{Name: "local", Storage: storage.CreateLocalStorage(config.LocalConfig{Path: filepath.Join(dir, "nas")})}
+	repo := New("repository/app/", []*storage.NamedStorage{st}, Options{
+		PackSize: 1024 * 1024,
+		Encrypt:  encrypt,
+		Decrypt: func(r io.Reader) (io.Reader, error) {
+			return utils.NewDecryptReader(r, identityFile, "")
+		},
+		CachePath: filepath.Join(dir, "state", "cache.json"),
+	})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return now }
+	first, err := repo.Backup([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Files != 3 || first.NewChunks == 0 || first.Errs[0] != nil {
+		t.Fatalf("first backup = %+v", first)
+	}
+
+	// 追加数据后只上传变化的块
+	if err := os.Remove(filepath.Join(source, "old.bin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "big.bin"), append(big, []byte("appended")...), 0640); err != nil {
+		t.Fatal(err)
+	}
+	now = now.AddDate(0, 0, 40)
+	second, err := repo.Backup([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.NewChunks >= first.NewChunks || second.NewChunks == 0 {
+		t.Errorf("second backup new chunks = %d, first = %d", second.NewChunks, first.NewChunks)
+	}
+
+	// 未变化时不上传任何数据
+	now = now.Add(time.Hour)
+	third, err := repo.Backup([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.NewChunks != 0 || third.Uploaded != 0 {
+		t.Errorf("third backup = %+v", third)
+	}
+
+	names, err := repo.Snapshots(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("snapshots = %v", names)
+	}
+
+	// 恢复第一个快照
+	target := filepath.Join(dir, "restore")
+	if err := repo.Restore(st, names[0], target); err != nil {
+		t.Fatal(err)
+	}
+	files["old.bin"] = old
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(target, "data", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s content mismatch", name)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(target, "data", "link")); err != nil || link != "sub/small.txt" {
+		t.Errorf("link = %q, %v", link, err)
+	}
+
+	// 加密的仓库没有私钥无法恢复，清理不需要私钥
+	noKey := New("repository/app/", []*storage.NamedStorage{st}, Options{})
+	noKey.now = func() time.Time { return now }
+	if err := noKey.Restore(st, "", filepath.Join(dir, "nokey")); err == nil {
+		t.Error("restore without identity should fail")
+	}
+
+	result, err := noKey.Prune(st, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Snapshots != 1 || result.Kept != 2 || result.Packs == 0 {
+		t.Errorf("prune = %+v", result)
+	}
+
+	target = filepath.Join(dir, "latest")
+	if err := repo.Restore(st, "", target); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(target, "data", "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(big, []byte("appended")...)) {
+		t.Error("latest big.bin content mismatch")
+	}
+}
+
+func TestRepositoryKey(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "data")
+	if err := os.MkdirAll(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("known content")
+	if err := os.WriteFile(filepath.Join(source, "a.txt"), content, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypt, _, err := utils.NewEncryptor([]string{identity.Recipient().String()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas := filepath.Join(dir, "nas")
+	st := &storage.NamedStorage{Name: "local", Storage: storage.CreateLocalStorage(config.LocalConfig{Path: nas})}
+	opts := Options{Encrypt: encrypt, KeyPath: filepath.Join(dir, "state", "repo.key")}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRepo := func(opts Options) *Repository {
+		repo := New("repository/app/", []*storage.NamedStorage{st}, opts)
+		repo.now = func() time.Time { return now }
+		return repo
+	}
+
+	// 备份主机只有公钥，密钥随机生成并保存到本地，仓库中的密钥是加密的
+	result, err := newRepo(opts).Backup([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(opts.KeyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("local key = %v, %v", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(nas, "repository", "app", keyObject)); err != nil || !bytes.HasPrefix(data, []byte(ageMagic)) {
+		t.Fatalf("repository key should be encrypted, err = %v", err)
+	}
+
+	// 明文的快照中不能出现内容的 SHA256
+	data, err := os.ReadFile(filepath.Join(nas, "repository", "app", "snapshots", result.Snapshot+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(objectID(nil, content))) {
+		t.Error("snapshot leaks plaintext chunk hash")
+	}
+
+	// 本地密钥丢失后需要私钥读取仓库中的密钥，之后仍然去重
+	if err := os.Remove(opts.KeyPath); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := newRepo(opts).Backup([]string{source}); err == nil {
+		t.Fatal("backup without local key and identity should fail")
+	}
+	opts.Decrypt = func(r io.Reader) (io.Reader, error) {
+		return utils.NewDecryptReader(r, identityFile, "")
+	}
+	repo := newRepo(opts)
+	result, err = repo.Backup([]string{source})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NewChunks != 0 {
+		t.Errorf("backup with recovered key new chunks = %d", result.NewChunks)
+	}
+	if _, err := os.Stat(opts.KeyPath); err != nil {
+		t.Errorf("local key not restored: %v", err)
+	}
+
+	target := filepath.Join(dir, "restore")
+	if err := repo.Restore(st, "", target); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(target, "data", "a.txt")); !bytes.Equal(got, content) {
+		t.Errorf("restored content %q", got)
+	}
+
+	// 本地密钥与仓库中的不一致时失败，不写入以其他密钥计算 ID 的块
+	if err := os.WriteFile(opts.KeyPath, []byte(strings.Repeat("ab", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts.Decrypt = nil
+	now = now.Add(time.Hour)
+	if _, err := newRepo(opts).Backup([]string{source}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("backup with mismatched local key err = %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"backup-go/storage"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// blobLocation 块所在的 pack 和位置
+type blobLocation struct {
+	pack   string
+	offset int64
+	length int64
+}
+
+// Restore 把快照恢复到 target 目录，snapshot 为空时恢复最新的快照
+func (r *Repository) Restore(st *storage.NamedStorage, snapshot, target string) error {
+	names, err := r.Snapshots(st)
+	if err != nil {
+		return err
+	}
+	if len(names) <= 0 {
+		return errors.New("no snapshot in repository")
+	}
+	if snapshot == "" {
+		snapshot = names[len(names)-1]
+	}
+	if !slices.Contains(names, snapshot) {
+		return fmt.Errorf("snapshot %s not found", snapshot)
+	}
+
+	var snap Snapshot
+	if err := r.readJSON(st, r.root+snapshotDir+snapshot+".json", &snap); err != nil {
+		return err
+	}
+	var tree Tree
+	if err := r.readJSON(st, snap.Tree, &tree); err != nil {
+		return err
+	}
+
+	index, _, err := r.loadIndex(st)
+	if err != nil {
+		return err
+	}
+	locations := make(map[string]blobLocation)
+	for _, p := range index.Packs {
+		for _, b := range p.Blobs {
+			locations[b.ID] = blobLocation{pack: p.Pack, offset: b.Offset, length: b.Length}
+		}
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	// 加密的仓库中块 ID 是 HMAC，需要仓库密钥才能校验
+	exists, err := r.hasKey(st)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	if exists {
+		if key, err = r.readKey(st); err != nil {
+			return fmt.Errorf("load repository key failed: %w", err)
+		}
+	}
+
+	reader := &blobReader{repo: r, st: st, key: key, locations: locations, decoder: decoder}
+	var dirs, links []Node
+	for _, node := range tree.Nodes {
+		if !filepath.IsLocal(node.Name) {
+			return fmt.Errorf("invalid path %s in snapshot", node.Name)
+		}
+		dst := filepath.Join(target, filepath.FromSlash(node.Name))
+
+		switch node.Type {
+		case NodeDir:
+			if err := os.MkdirAll(dst, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, node)
+		case NodeSymlink:
+			links = append(links, node)
+		case NodeFile:
+			if err := reader.restoreFile(node, dst); err != nil {
+				return fmt.Errorf("restore %s failed: %w", node.Name, err)
+			}
+		}
+	}
+
+	// 符号链接在文件写完后创建，避免通过链接写到目标目录之外
+	for _, node := range links {
+		dst := filepath.Join(target, filepath.FromSlash(node.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		os.Remove(dst)
+		if err := os.Symlink(node.Link, dst); err != nil {
+			return err
+		}
+	}
+
+	// 目录的权限和时间最后设置，避免写入文件时被修改或没有写权限
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dst := filepath.Join(target, filepath.FromSlash(dirs[i].Name))
+		os.Chmod(dst, dirs[i].Mode.Perm())
+		os.Chtimes(dst, dirs[i].ModTime, dirs[i].ModTime)
+	}
+	return nil
+}
+
+// blobReader 按块 ID 读取数据，缓存最近一个 pack，同一文件的块通常在同一个 pack 中
+type blobReader struct {
+	repo      *Repository
+	st        storage.Storage
+	key       []byte
+	locations map[string]blobLocation
+	decoder   *zstd.Decoder
+	packName  string
+	packData  []byte
+}
+
+func (br *blobReader) restoreFile(node Node, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range node.Chunks {
+		data, err := br.read(id)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dst, node.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, node.ModTime, node.ModTime)
+}
+
+func (br *blobReader) read(id string) ([]byte, error) {
+	loc, ok := br.locations[id]
+	if !ok {
+		return nil, fmt.Errorf("chunk %s not found in index", id)
+	}
+
+	if br.packName != loc.pack {
+		data, err := br.repo.readObject(br.st, br.repo.root+packDir+loc.pack)
+		if err != nil {
+			return nil, err
+		}
+		br.packName, br.packData = loc.pack, data
+	}
+	if loc.offset+loc.length > int64(len(br.packData)) {
+		return nil, fmt.Errorf("chunk %s out of pack %s", id, loc.pack)
+	}
+
+	data, err := br.decoder.DecodeAll(br.packData[loc.offset:loc.offset+loc.length], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress chunk %s failed: %w", id, err)
+	}
+	if id != objectID(br.key, data) {
+		return nil, fmt.Errorf("chunk %s checksum mismatch", id)
+	}
+	return data, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"backup-go/config"
+	"backup-go/repository"
+	"backup-go/storage"
+	"backup-go/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// restore 从存储下载备份，加密的备份解密后输出，用法：
+//
+//	backup-go restore -task app -key app_2024_01_01.zip.age -out ./app.zip [-storage oss] [-identity key.txt]
+//
+// 仓库模式的任务恢复快照到 -out 目录，-snapshot 为空时恢复最新快照：
+//
+//	backup-go restore -task app -out ./app [-snapshot 20240101-002500] [-storage oss] [-identity key.txt]
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	task := fs.String("task", "", "backup task id")
+	key := fs.String("key", "", "object key to restore")
+	out := fs.String("out", "", "output file, default object file name; output directory in repository mode")
+	snapshot := fs.String("snapshot", "", "snapshot to restore in repository mode, default latest")
+	storageName := fs.String("storage", "", "storage name, default first storage of the task")
+	identity := fs.String("identity", "", "age identity file, default encrypt.identity_file of the task")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, ok := config.Config.BackupConf[*task]
+	if !ok {
+		return fmt.Errorf("task %s not found", *task)
+	}
+	if *key == "" && conf.Repository == nil {
+		return errors.New("key can not be empty")
+	}
+
+	name := *storageName
+	if name == "" {
+		name = conf.GetStorages()[0]
+	}
+	sc, err := config.Config.ResolveStorage(name)
+	if err != nil {
+		return err
+	}
+	st := storage.GetStorage(conf.ApplyOverride(sc))
+
+	var identityFile, passphrase string
+	if conf.Encrypt != nil {
+		identityFile, passphrase = conf.Encrypt.IdentityFile, conf.Encrypt.Passphrase
+	}
+	if *identity != "" {
+		identityFile = *identity
+	}
+
+	if conf.Repository != nil {
+		if *out == "" {
+			return errors.New("out can not be empty in repository mode")
+		}
+		repo := repository.New(conf.Repository.GetPath(*task), nil, repository.Options{
+			Decrypt: func(r io.Reader) (io.Reader, error) {
+				return utils.NewDecryptReader(r, identityFile, passphrase)
+			},
+		})
+		log.Printf("restore snapshot %q from %s to %s", *snapshot, name, *out)
+		return repo.Restore(&storage.NamedStorage{Name: name, Storage: st}, *snapshot, *out)
+	}
+
+	// 分卷备份可以指定任意一个分卷或去掉分卷后缀的名称
+	objKey := utils.TrimVolumeSuffix(*key)
+	volumes, err := volumeKeys(st, objKey)
+	if err != nil {
+		return err
+	}
+	if len(volumes) <= 0 {
+		objKey = *key
+	}
+
+	target := *out
+	if target == "" {
+		target = strings.TrimSuffix(path.Base(objKey), utils.EncryptedSuffix)
+	}
+
+	download := target + ".download"
+	defer os.Remove(download)
+	if len(volumes) > 0 {
+		log.Printf("download %d volumes of %s from %s to %s", len(volumes), objKey, name, download)
+		if err := downloadVolumes(st, volumes, download); err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+	} else {
+		log.Printf("download %s from %s to %s", objKey, name, download)
+		if err := st.Get(objKey, download); err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+	}
+
+	if !strings.HasSuffix(objKey, utils.EncryptedSuffix) {
+		return os.Rename(download, target)
+	}
+
+	log.Printf("decrypt %s to %s", download, target)
+	return decryptFile(download, target, identityFile, passphrase)
+}
+
+// volumeKeys 返回 objKey 按序号排列的分卷，不是分卷备份时返回空，分卷不连续时返回错误
+func volumeKeys(st storage.Storage, objKey string) ([]string, error) {
+	objects, err := st.List(objKey + ".")
+	if err != nil {
+		return nil, fmt.Errorf("list volumes failed: %w", err)
+	}
+
+	existing := make(map[string]bool)
+	for _, object := range objects {
+		if object.Key != objKey && utils.TrimVolumeSuffix(object.Key) == objKey {
+			existing[object.Key] = true
+		}
+	}
+
+	var keys []string
+	for n := 1; existing[objKey+utils.VolumeSuffix(n)]; n++ {
+		keys = append(keys, objKey+utils.VolumeSuffix(n))
+	}
+	if len(keys) != len(existing) {
+		return nil, fmt.Errorf("volume %s missing", objKey+utils.VolumeSuffix(len(keys)+1))
+	}
+	return keys, nil
+}
+
+// downloadVolumes 依次下载分卷并拼接到 dst
+func downloadVolumes(st storage.Storage, keys []string, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	part := dst + ".part"
+	defer os.Remove(part)
+	for _, key := range keys {
+		log.Printf("download volume %s", key)
+		if err := st.Get(key, part); err != nil {
+			return err
+		}
+		in, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func decryptFile(src, dst, identityFile, passphrase string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := utils.NewDecryptReader(in, identityFile, passphrase)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("decrypt failed: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"backup-go/config"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// coolDownStore 记录每个 endpoint 最近一次上传成功的时间，持久化到本地状态文件，重启后冷却期依然有效
+type coolDownStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+var (
+	defaultCoolDownStore     *coolDownStore
+	defaultCoolDownStoreOnce sync.Once
+)
+
+// getCoolDownStore 所有 OSS 客户端共享同一个状态文件
+func getCoolDownStore() *coolDownStore {
+	defaultCoolDownStoreOnce.Do(func() {
+		defaultCoolDownStore = &coolDownStore{
+			path: filepath.Join(config.Config.GetStateDir(), "cooldown.json"),
+		}
+	})
+	return defaultCoolDownStore
+}
+
+// LastSuccess 返回 key 最近一次上传成功的时间，没有记录时返回零值
+func (s *coolDownStore) LastSuccess(key string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, _ := s.load()
+	return state[key]
+}
+
+// SetLastSuccess 记录 key 上传成功的时间
+func (s *coolDownStore) SetLastSuccess(key string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load()
+	if err != nil {
+		// 状态文件损坏时重新生成
+		state = make(map[string]time.Time)
+	}
+	state[key] = t
+
+	return s.save(state)
+}
+
+func (s *coolDownStore) load() (map[string]time.Time, error) {
+	state := make(map[string]time.Time)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return make(map[string]time.Time), err
+	}
+	return state, nil
+}
+
+func (s *coolDownStore) save(state map[string]time.Time) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCoolDownStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "cooldown.json")
+	store := &coolDownStore{path: path}
+
+	if !store.LastSuccess("oss-accelerate.aliyuncs.com/bucket").IsZero() {
+		t.Fatal("expect zero time without state file")
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if err := store.SetLastSuccess("oss-accelerate.aliyuncs.com/bucket", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟重启后重新读取状态文件
+	reloaded := &coolDownStore{path: path}
+	if got := reloaded.LastSuccess("oss-accelerate.aliyuncs.com/bucket"); !got.Equal(now) {
+		t.Fatalf("reload got %v, want %v", got, now)
+	}
+	if !reloaded.LastSuccess("other/bucket").IsZero() {
+		t.Fatal("expect zero time for other endpoint")
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"backup-go/config"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage 本地目录存储，可用于挂载的 NAS 目录
+type LocalStorage struct {
+	root    string
+	limiter *bandwidthLimiter
+}
+
+var _ StreamStorage = (*LocalStorage)(nil)
+
+func CreateLocalStorage(conf config.LocalConfig) *LocalStorage {
+	if conf.Path == "" {
+		panic("local path can not be empty")
+	}
+
+	root := filepath.Clean(conf.Path)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		panic(err)
+	}
+
+	log.Printf("local storage init done: %s", root)
+
+	return &LocalStorage{root: root, limiter: newBandwidthLimiter(conf.RateLimit)}
+}
+
+func (ls *LocalStorage) GetName() string {
+	return "Local"
+}
+
+func (ls *LocalStorage) Put(objKey, filePath string, _ PutOptions, noticeFunc UploadNoticeFunc) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	target := ls.objPath(objKey)
+	noticeFunc(fmt.Sprintf("copy to 【%s】", target))
+	defer ls.limiter.report(target, noticeFunc)()
+
+	if err := writeFile(target, ls.limiter.reader(in)); err != nil {
+		noticeFunc(fmt.Sprintf("copy to 【%s】 failed, error: %v", target, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("copy to 【%s】 success", target))
+	return nil
+}
+
+func (ls *LocalStorage) PutStream(objKey string, reader io.Reader, _ int64, _ PutOptions, noticeFunc UploadNoticeFunc) error {
+	target := ls.objPath(objKey)
+	noticeFunc(fmt.Sprintf("stream to 【%s】", target))
+	defer ls.limiter.report(target, noticeFunc)()
+
+	if err := writeFile(target, ls.limiter.reader(reader)); err != nil {
+		noticeFunc(fmt.Sprintf("stream to 【%s】 failed, error: %v", target, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("stream to 【%s】 success", target))
+	return nil
+}
+
+func (ls *LocalStorage) List(prefix string) ([]ObjectInfo, error) {
+	// 只遍历前缀所在的目录
+	start := filepath.Join(ls.root, filepath.FromSlash(prefixDir(prefix)))
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == start {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ls.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		// 跳过未完成的临时文件
+		if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, tmpSuffix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func (ls *LocalStorage) Delete(keys []string) ([]string, error) {
+	var deleted []string
+	var errs []error
+	for _, key := range keys {
+		if err := os.Remove(ls.objPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+func (ls *LocalStorage) Get(objKey, filePath string) error {
+	return copyFile(ls.objPath(objKey), filePath)
+}
+
+func (ls *LocalStorage) Stat(objKey string) (*ObjectInfo, error) {
+	info, err := os.Stat(ls.objPath(objKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:          objKey,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (ls *LocalStorage) objPath(objKey string) string {
+	return filepath.Join(ls.root, filepath.FromSlash(objKey))
+}
+
+const tmpSuffix = ".tmp"
+
+// copyFile 先写入临时文件再重命名，避免中断时留下不完整的文件
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(dst, in)
+}
+
+// writeFile 先写入临时文件，完成后再重命名，避免中断后留下不完整的备份
+func writeFile(dst string, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp := dst + tmpSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
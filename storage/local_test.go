@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"backup-go/config"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	ls := CreateLocalStorage(config.LocalConfig{Path: filepath.Join(dir, "nas")})
+
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, []byte("backup content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"app_2024_01_01.zip", "sub/app_2024_01_02.zip"} {
+		if err := ls.Put(key, src, PutOptions{}, func(string) {}); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	objects, err := ls.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("list got %+v", objects)
+	}
+
+	objects, err = ls.List("sub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "sub/app_2024_01_02.zip" {
+		t.Fatalf("list sub/ got %+v", objects)
+	}
+
+	info, err := ls.Stat("sub/app_2024_01_02.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("backup content")) {
+		t.Fatalf("stat size %d", info.Size)
+	}
+
+	deleted, err := ls.Delete([]string{"app_2024_01_01.zip", "missing.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("deleted %v", deleted)
+	}
+
+	dst := filepath.Join(dir, "dst.zip")
+	if err := ls.Get("sub/app_2024_01_02.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "backup content" {
+		t.Fatalf("get content %q", b)
+	}
+}
+
+func TestLocalStoragePutStream(t *testing.T) {
+	dir := t.TempDir()
+	ls := CreateLocalStorage(config.LocalConfig{Path: dir})
+
+	if err := ls.PutStream("app_2024_01_01.zip", strings.NewReader("backup content"), 0, PutOptions{}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "app_2024_01_01.zip")); string(b) != "backup content" {
+		t.Fatalf("stream content %q", b)
+	}
+
+	// reader 出错时不留下目标文件和临时文件
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("partial"))
+		pw.CloseWithError(errors.New("zip failed"))
+	}()
+	if err := ls.PutStream("app_2024_01_02.zip", pr, 0, PutOptions{}, func(string) {}); err == nil {
+		t.Fatal("put stream should fail when reader fails")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("unexpected files after failed stream %v", entries)
+	}
+}
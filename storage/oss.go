@@ -0,0 +1,748 @@
+package storage
+
+import (
+	"backup-go/config"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+type (
+	NamedBucket struct {
+		Name   string
+		Bucket *oss.Bucket
+		retry  retryPolicy
+	}
+
+	OssClient struct {
+		slowBucket   *NamedBucket
+		fastBucket   *NamedBucket
+		prefix       keyPrefix
+		multipart    multipartConfig
+		fastCooldown time.Duration
+		coolDown     *coolDownStore
+		limiter      *bandwidthLimiter
+	}
+
+	// multipartConfig 分片上传配置
+	multipartConfig struct {
+		threshold     int64
+		partSize      int64
+		concurrency   int
+		checkpointDir string
+	}
+)
+
+const (
+	defaultMultipartThreshold = 100 * 1024 * 1024
+	defaultPartSize           = 10 * 1024 * 1024
+	defaultPartConcurrency    = 3
+	maxPartCount              = 10000 // OSS 单个文件最多 10000 个分片
+	defaultFastCooldown       = 3 * 24 * time.Hour
+)
+
+var (
+	_ StreamStorage    = (*OssClient)(nil)
+	_ ResumableStorage = (*OssClient)(nil)
+)
+
+func CreateOSSClient(config config.OssConfig) *OssClient {
+	limiter := newBandwidthLimiter(config.RateLimit)
+	ossClient := &OssClient{
+		slowBucket: must(getBucket(
+			"SLOW",
+			config.Endpoint,
+			config.AccessKey,
+			config.AccessKeySecret,
+			config.BucketName,
+			config.Retry,
+			limiter)), // slowBucket must not nil
+		fastBucket: getBucket(
+			"FAST",
+			config.FastEndpoint,
+			config.AccessKey,
+			config.AccessKeySecret,
+			config.BucketName,
+			config.FastRetry,
+			limiter,
+		),
+		prefix:       newKeyPrefix(config.Prefix),
+		multipart:    newMultipartConfig(config),
+		fastCooldown: config.FastCooldown,
+		coolDown:     getCoolDownStore(),
+		limiter:      limiter,
+	}
+	if ossClient.fastCooldown <= 0 {
+		ossClient.fastCooldown = defaultFastCooldown
+	}
+
+	log.Printf("oss client init done: %v", ossClient)
+
+	return ossClient
+}
+
+func newMultipartConfig(conf config.OssConfig) multipartConfig {
+	mc := multipartConfig{
+		threshold:     int64(conf.MultipartThreshold),
+		partSize:      int64(conf.PartSize),
+		concurrency:   conf.PartConcurrency,
+		checkpointDir: conf.CheckpointDir,
+	}
+
+	if mc.threshold <= 0 {
+		mc.threshold = defaultMultipartThreshold
+	}
+	if mc.partSize <= 0 {
+		mc.partSize = defaultPartSize
+	}
+	mc.partSize = min(max(mc.partSize, oss.MinPartSize), oss.MaxPartSize)
+	if mc.concurrency <= 0 {
+		mc.concurrency = defaultPartConcurrency
+	}
+	if mc.checkpointDir == "" {
+		mc.checkpointDir = filepath.Join(os.TempDir(), "backup-go", "checkpoint")
+	}
+
+	return mc
+}
+
+// partSizeFor 返回文件实际使用的分片大小，保证分片数不超过上限
+func (mc multipartConfig) partSizeFor(fileSize int64) int64 {
+	partSize := mc.partSize
+	if fileSize/partSize >= maxPartCount {
+		partSize = fileSize/(maxPartCount-1) + 1
+	}
+	return min(partSize, oss.MaxPartSize)
+}
+
+func (oc *OssClient) GetName() string {
+	return "OSS"
+}
+
+func (oc *OssClient) Put(objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	return oc.Upload(objKey, filePath, opts, noticeFunc)
+}
+
+func (oc *OssClient) Upload(objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) (err error) {
+	if oc.slowBucket == nil && oc.fastBucket == nil {
+		return errors.New("client not init")
+	}
+	defer oc.limiter.report(oc.GetName(), noticeFunc)()
+
+	err = oc.upload(oc.slowBucket, objKey, filePath, opts, noticeFunc)
+	if err == nil {
+		return
+	}
+
+	// 没有配置 fast bucket 时返回 slow bucket 重试用尽后的错误
+	if oc.fastBucket == nil || oc.fastBucket.Bucket == nil {
+		return
+	}
+	if !oc.canUseFastBucket() {
+		noticeFunc(fmt.Sprintf("fast bucket in %v cooldown, available at %s",
+			oc.fastCooldown, oc.FastBucketAvailableAt().Format("2006-01-02 15:04:05")))
+		return ErrCoolDown
+	}
+
+	err = oc.upload(oc.fastBucket, objKey, filePath, opts, noticeFunc)
+	if err == nil {
+		return
+	}
+
+	return
+}
+
+func (oc *OssClient) upload(bucket *NamedBucket, objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	if bucket == nil || bucket.Bucket == nil {
+		return errors.New("bucket not init")
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+
+	fullKey := oc.prefix.full(objKey)
+	err = bucket.retry.Do(bucket.Name, noticeFunc, func() error {
+		return oc.putObject(bucket, fullKey, filePath, info.Size(), opts, noticeFunc)
+	})
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket upload failed, error: %v", bucket.Name, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket upload success", bucket.Name))
+	if err := oc.coolDown.SetLastSuccess(bucket.stateKey(), time.Now()); err != nil {
+		log.Printf("save cooldown state failed: %v", err)
+	}
+
+	return nil
+}
+
+func (oc *OssClient) putObject(bucket *NamedBucket, fullKey, filePath string, size int64, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	if size >= oc.multipart.threshold {
+		// 大文件使用分片上传，断点记录按对象保存在 checkpointDir，失败后保留，
+		// 下次上传同一文件时从已完成的分片继续
+		cpPath := oc.checkpointPath(bucket, fullKey)
+		if err := oc.prepareCheckpoint(bucket, cpPath, filePath, noticeFunc); err != nil {
+			return err
+		}
+		partSize := oc.multipart.partSizeFor(size)
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket multipart uploading, part size %d, concurrency %d",
+			bucket.Name, partSize, oc.multipart.concurrency))
+		options := append(ossObjectOptions(opts),
+			oss.Routines(oc.multipart.concurrency),
+			oss.Checkpoint(true, cpPath),
+			oss.Progress(newUploadProgressListener(bucket.Name, noticeFunc)),
+		)
+		err := bucket.Bucket.UploadFile(fullKey, filePath, partSize, options...)
+		if isNoSuchUpload(err) {
+			// 断点记录中的分片上传已被取消，删除记录后重新上传
+			noticeFunc(fmt.Sprintf("use 【%s】 bucket multipart upload in checkpoint not found, restart", bucket.Name))
+			os.Remove(cpPath)
+			err = bucket.Bucket.UploadFile(fullKey, filePath, partSize, options...)
+		}
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket uploading", bucket.Name))
+	options := ossObjectOptions(opts)
+	if md5 := opts.Checksum.ContentMD5(); md5 != "" {
+		options = append(options, oss.ContentMD5(md5))
+	}
+	return bucket.Bucket.PutObjectFromFile(fullKey, filePath, options...)
+}
+
+// ossCheckpoint SDK 断点记录中用到的字段
+type ossCheckpoint struct {
+	FilePath  string
+	ObjectKey string
+	UploadID  string
+	FileStat  struct {
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+func loadCheckpoint(cpPath string) (*ossCheckpoint, error) {
+	data, err := os.ReadFile(cpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp ossCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s failed: %w", cpPath, err)
+	}
+	return &cp, nil
+}
+
+// checkpointPath 断点记录按 bucket 和对象命名，与本地文件路径无关，同一对象再次上传时找到上次的记录
+func (oc *OssClient) checkpointPath(bucket *NamedBucket, fullKey string) string {
+	sum := md5.Sum([]byte(bucket.stateKey() + "/" + fullKey))
+	return filepath.Join(oc.multipart.checkpointDir, hex.EncodeToString(sum[:])+".cp")
+}
+
+// prepareCheckpoint 创建断点记录目录（SDK 不创建目录，写入失败时不报错），
+// 已有记录属于其他文件或文件已变化时取消其分片上传并删除记录；SDK 遇到这样的记录会直接开始新的上传，
+// 旧的分片一直留在服务端
+func (oc *OssClient) prepareCheckpoint(bucket *NamedBucket, cpPath, filePath string, noticeFunc UploadNoticeFunc) error {
+	if err := os.MkdirAll(oc.multipart.checkpointDir, 0750); err != nil {
+		return fmt.Errorf("create checkpoint dir failed: %w", err)
+	}
+
+	cp, err := loadCheckpoint(cpPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		// 无法解析的记录由 SDK 删除后重新上传
+		return nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	cpFile, _ := filepath.Abs(cp.FilePath)
+	file, _ := filepath.Abs(filePath)
+	if cpFile == file && cp.FileStat.Size == info.Size() && cp.FileStat.LastModified.Equal(info.ModTime()) {
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket resume multipart upload %s", bucket.Name, cp.UploadID))
+		return nil
+	}
+
+	if err := abortCheckpoint(bucket, cpPath, cp); err != nil {
+		// 取消失败的上传留给 CleanupUploads 清理
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket abort stale multipart upload failed: %v", bucket.Name, err))
+	}
+	return nil
+}
+
+// abortCheckpoint 取消断点记录对应的分片上传并删除记录，上传已不存在时也删除记录
+func abortCheckpoint(bucket *NamedBucket, cpPath string, cp *ossCheckpoint) error {
+	if cp.UploadID != "" {
+		err := bucket.Bucket.AbortMultipartUpload(oss.InitiateMultipartUploadResult{
+			Bucket:   bucket.Bucket.BucketName,
+			Key:      cp.ObjectKey,
+			UploadID: cp.UploadID,
+		})
+		if err != nil && !isNoSuchUpload(err) {
+			return fmt.Errorf("abort multipart upload %s failed: %w", cp.UploadID, err)
+		}
+	}
+
+	if err := os.Remove(cpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func isNoSuchUpload(err error) bool {
+	var serviceErr oss.ServiceError
+	return errors.As(err, &serviceErr) && serviceErr.Code == "NoSuchUpload"
+}
+
+// AbortUpload 取消 objKey 在各 bucket 未完成的分片上传并删除断点记录
+func (oc *OssClient) AbortUpload(objKey string) error {
+	fullKey := oc.prefix.full(objKey)
+
+	var errs []error
+	for _, bucket := range oc.buckets() {
+		cpPath := oc.checkpointPath(bucket, fullKey)
+		cp, err := loadCheckpoint(cpPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				os.Remove(cpPath)
+			}
+			continue
+		}
+		if err := abortCheckpoint(bucket, cpPath, cp); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupUploads 进程中断、放弃续传等情况留下的分片上传一直占用存储空间，按发起时间取消；
+// 未过期的断点记录对应的上传仍可能续传，不取消
+func (oc *OssClient) CleanupUploads(before time.Time) (int, error) {
+	inUse := make(map[string]bool)
+	entries, err := os.ReadDir(oc.multipart.checkpointDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".cp" {
+			continue
+		}
+		cpPath := filepath.Join(oc.multipart.checkpointDir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(before) {
+			os.Remove(cpPath)
+			continue
+		}
+		if cp, err := loadCheckpoint(cpPath); err == nil {
+			inUse[cp.UploadID] = true
+		}
+	}
+
+	aborted := 0
+	var errs []error
+	for _, bucket := range oc.buckets() {
+		n, err := oc.cleanupBucketUploads(bucket, before, inUse)
+		aborted += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cleanup 【%s】 bucket uploads failed: %w", bucket.Name, err))
+		}
+	}
+	return aborted, errors.Join(errs...)
+}
+
+func (oc *OssClient) cleanupBucketUploads(bucket *NamedBucket, before time.Time, inUse map[string]bool) (int, error) {
+	aborted := 0
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		resp, err := bucket.Bucket.ListMultipartUploads(oss.Prefix(oc.prefix.full("")),
+			oss.KeyMarker(keyMarker), oss.UploadIDMarker(uploadIDMarker))
+		if err != nil {
+			return aborted, err
+		}
+
+		for _, upload := range resp.Uploads {
+			if inUse[upload.UploadID] || !upload.Initiated.Before(before) {
+				continue
+			}
+			err := bucket.Bucket.AbortMultipartUpload(oss.InitiateMultipartUploadResult{
+				Bucket:   bucket.Bucket.BucketName,
+				Key:      upload.Key,
+				UploadID: upload.UploadID,
+			})
+			if err != nil && !isNoSuchUpload(err) {
+				return aborted, err
+			}
+			aborted++
+		}
+		if !resp.IsTruncated {
+			return aborted, nil
+		}
+		keyMarker, uploadIDMarker = resp.NextKeyMarker, resp.NextUploadIDMarker
+	}
+}
+
+// buckets 返回已配置的 bucket
+func (oc *OssClient) buckets() []*NamedBucket {
+	var buckets []*NamedBucket
+	for _, bucket := range []*NamedBucket{oc.slowBucket, oc.fastBucket} {
+		if bucket != nil && bucket.Bucket != nil {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// PutStream 流式上传只使用 endpoint，数据无法重放，因此不回退到 fast_endpoint；
+// 分片逐个读取并上传，单个分片失败时按重试策略重传该分片
+func (oc *OssClient) PutStream(objKey string, reader io.Reader, sizeHint int64, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	bucket := oc.slowBucket
+	if bucket == nil || bucket.Bucket == nil {
+		return errors.New("bucket not init")
+	}
+
+	partSize := oc.multipart.partSizeFor(sizeHint)
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket streaming upload, part size %d", bucket.Name, partSize))
+	defer oc.limiter.report(oc.GetName(), noticeFunc)()
+
+	err := oc.putStream(bucket, oc.prefix.full(objKey), reader, partSize, opts, noticeFunc)
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket upload failed, error: %v", bucket.Name, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket upload success", bucket.Name))
+	if err := oc.coolDown.SetLastSuccess(bucket.stateKey(), time.Now()); err != nil {
+		log.Printf("save cooldown state failed: %v", err)
+	}
+
+	return nil
+}
+
+func (oc *OssClient) putStream(bucket *NamedBucket, fullKey string, reader io.Reader, partSize int64, opts PutOptions, noticeFunc UploadNoticeFunc) (err error) {
+	imur, err := bucket.Bucket.InitiateMultipartUpload(fullKey, ossObjectOptions(opts)...)
+	if err != nil {
+		return fmt.Errorf("initiate multipart upload failed: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			bucket.Bucket.AbortMultipartUpload(imur)
+		}
+	}()
+
+	var parts []oss.UploadPart
+	var uploaded int64
+	buf := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, readErr := io.ReadFull(reader, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read stream failed: %w", readErr)
+		}
+		// 空数据也需要上传一个分片才能完成
+		if n <= 0 && len(parts) > 0 {
+			break
+		}
+		if number > maxPartCount {
+			return fmt.Errorf("stream exceeds %d parts of %d bytes", maxPartCount, partSize)
+		}
+
+		var part oss.UploadPart
+		err = bucket.retry.Do(bucket.Name, noticeFunc, func() error {
+			var err error
+			part, err = bucket.Bucket.UploadPart(imur, bytes.NewReader(buf[:n]), int64(n), number)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+
+		uploaded += int64(n)
+		if number%10 == 0 {
+			noticeFunc(fmt.Sprintf("use 【%s】 bucket uploaded %d parts, %d bytes", bucket.Name, number, uploaded))
+		}
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	if _, err = bucket.Bucket.CompleteMultipartUpload(imur, parts); err != nil {
+		return fmt.Errorf("complete multipart upload failed: %w", err)
+	}
+	return nil
+}
+
+// ossObjectOptions 转换存储类型、加密、ACL、元数据和标签
+func ossObjectOptions(opts PutOptions) []oss.Option {
+	var options []oss.Option
+	if opts.StorageClass != "" {
+		options = append(options, oss.ObjectStorageClass(oss.StorageClassType(opts.StorageClass)))
+	}
+	if opts.Encryption != "" {
+		options = append(options, oss.ServerSideEncryption(opts.Encryption))
+		if opts.KMSKeyID != "" {
+			options = append(options, oss.ServerSideEncryptionKeyID(opts.KMSKeyID))
+		}
+	}
+	if opts.ACL != "" {
+		options = append(options, oss.ObjectACL(oss.ACLType(opts.ACL)))
+	}
+	for k, v := range opts.Metadata {
+		options = append(options, oss.Meta(k, metadataValue(v)))
+	}
+	if len(opts.Tags) > 0 {
+		var tagging oss.Tagging
+		for k, v := range opts.Tags {
+			tagging.Tags = append(tagging.Tags, oss.Tag{Key: k, Value: v})
+		}
+		options = append(options, oss.SetTagging(tagging))
+	}
+	return options
+}
+
+// uploadProgressListener 分片上传进度，每完成 10% 通知一次
+type uploadProgressListener struct {
+	name       string
+	noticeFunc UploadNoticeFunc
+	lastStep   int64
+}
+
+func newUploadProgressListener(name string, noticeFunc UploadNoticeFunc) *uploadProgressListener {
+	return &uploadProgressListener{name: name, noticeFunc: noticeFunc}
+}
+
+func (l *uploadProgressListener) ProgressChanged(event *oss.ProgressEvent) {
+	if event.EventType != oss.TransferDataEvent || event.TotalBytes <= 0 {
+		return
+	}
+
+	step := event.ConsumedBytes * 10 / event.TotalBytes
+	if step <= l.lastStep {
+		return
+	}
+	l.lastStep = step
+	l.noticeFunc(fmt.Sprintf("use 【%s】 bucket uploaded %d%%", l.name, step*10))
+}
+
+func (oc *OssClient) List(prefix string) ([]ObjectInfo, error) {
+	bucket := oc.GetSlowClient()
+
+	var objects []ObjectInfo
+	token := ""
+	for {
+		resp, err := bucket.ListObjectsV2(oss.Prefix(oc.prefix.full(prefix)), oss.MaxKeys(100), oss.ContinuationToken(token))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range resp.Objects {
+			objects = append(objects, ObjectInfo{
+				Key:          oc.prefix.trim(object.Key),
+				Size:         object.Size,
+				LastModified: object.LastModified,
+				ETag:         strings.Trim(object.ETag, `"`),
+			})
+		}
+		if !resp.IsTruncated {
+			break
+		}
+		token = resp.NextContinuationToken
+	}
+
+	return objects, nil
+}
+
+func (oc *OssClient) Delete(keys []string) ([]string, error) {
+	bucket := oc.GetSlowClient()
+
+	// DeleteObjects 单次最多删除 1000 个对象
+	var deleted []string
+	for start := 0; start < len(keys); start += 1000 {
+		end := min(start+1000, len(keys))
+
+		var fullKeys []string
+		for _, key := range keys[start:end] {
+			fullKeys = append(fullKeys, oc.prefix.full(key))
+		}
+
+		resp, err := bucket.DeleteObjects(fullKeys)
+		if err != nil {
+			return deleted, err
+		}
+		for _, key := range resp.DeletedObjects {
+			deleted = append(deleted, oc.prefix.trim(key))
+		}
+	}
+
+	return deleted, nil
+}
+
+func (oc *OssClient) Get(objKey, filePath string) error {
+	return oc.GetSlowClient().GetObjectToFile(oc.prefix.full(objKey), filePath)
+}
+
+func (oc *OssClient) Stat(objKey string) (*ObjectInfo, error) {
+	header, err := oc.GetSlowClient().GetObjectDetailedMeta(oc.prefix.full(objKey))
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(header.Get(oss.HTTPHeaderContentLength), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse content length failed: %w", err)
+	}
+	lastModified, _ := http.ParseTime(header.Get(oss.HTTPHeaderLastModified))
+
+	return &ObjectInfo{
+		Key:          objKey,
+		Size:         size,
+		LastModified: lastModified,
+		ETag:         strings.Trim(header.Get(oss.HTTPHeaderEtag), `"`),
+		MD5:          ossContentMD5(header),
+		CRC64:        header.Get(oss.HTTPHeaderOssCRC64),
+	}, nil
+}
+
+func (oc *OssClient) canUseFastBucket() bool {
+	return !time.Now().Before(oc.FastBucketAvailableAt())
+}
+
+// FastBucketAvailableAt 返回 fast bucket 冷却结束的时间，未配置 fast bucket 时返回零值
+func (oc *OssClient) FastBucketAvailableAt() time.Time {
+	if oc.fastBucket == nil || oc.fastBucket.Bucket == nil {
+		return time.Time{}
+	}
+
+	lastSuccess := oc.coolDown.LastSuccess(oc.fastBucket.stateKey())
+	if lastSuccess.IsZero() {
+		return time.Time{}
+	}
+	return lastSuccess.Add(oc.fastCooldown)
+}
+
+// ossContentMD5 KMS 加密的对象 ETag 不一定是内容 MD5，只用 CRC64 校验
+func ossContentMD5(header http.Header) string {
+	if header.Get(oss.HTTPHeaderOssServerSideEncryption) == "KMS" {
+		return ""
+	}
+	return etagMD5(header.Get(oss.HTTPHeaderEtag))
+}
+
+// stateKey 冷却状态按 endpoint 和 bucket 记录
+func (nb *NamedBucket) stateKey() string {
+	return nb.Bucket.Client.Config.Endpoint + "/" + nb.Bucket.BucketName
+}
+
+func (oc *OssClient) TempVisitLink(objKey string) (string, error) {
+	if oc.slowBucket == nil || oc.slowBucket.Bucket == nil {
+		return "", errors.New("bucket not init")
+	}
+
+	return oc.slowBucket.Bucket.SignURL(oc.prefix.full(objKey), oss.HTTPGet, 60*60*24*1)
+}
+
+func (oc *OssClient) GetSlowClient() *oss.Bucket {
+	return oc.slowBucket.Bucket
+}
+
+func must[T any](obj T) T {
+	if isNil(obj) {
+		panic(errors.New("obj is nil"))
+	}
+
+	return obj
+}
+
+func isNil[T any](obj T) bool {
+	v := reflect.ValueOf(obj)
+	kind := v.Kind()
+	return canBeNil(kind) && v.IsNil()
+}
+
+func canBeNil(kind reflect.Kind) bool {
+	return kind == reflect.Pointer ||
+		kind == reflect.Interface ||
+		kind == reflect.Slice ||
+		kind == reflect.Map ||
+		kind == reflect.Chan ||
+		kind == reflect.Func
+}
+
+func getBucket(customName, endpoint, ak, aks, buckatName string, retry config.RetryConfig, limiter *bandwidthLimiter) *NamedBucket {
+	if endpoint == "" || ak == "" || aks == "" || buckatName == "" {
+		return nil
+	}
+
+	options := []oss.ClientOption{oss.Timeout(ossConnectTimeout, ossReadWriteTimeout)}
+	if limiter != nil {
+		options = append(options, oss.HTTPClient(ossHTTPClient(limiter)))
+	}
+
+	client, err := oss.New(endpoint, ak, aks, options...)
+	if err != nil {
+		panic(err)
+	}
+
+	bucket, err := client.Bucket(buckatName)
+	if err != nil {
+		panic(err)
+	}
+
+	return &NamedBucket{
+		Name:   customName,
+		Bucket: bucket,
+		retry:  newRetryPolicy(retry),
+	}
+}
+
+// OSS 连接超时和读写超时，单位秒
+const (
+	ossConnectTimeout   = 10
+	ossReadWriteTimeout = 60 * 60 * 3
+)
+
+// ossHTTPClient 返回写入限速的 http.Client。使用自定义 client 时 SDK 不再创建自己的 transport，
+// 这里按 SDK 的方式设置连接、读写、响应头和空闲连接超时，并且不跟随重定向
+func ossHTTPClient(limiter *bandwidthLimiter) *http.Client {
+	timeout := ossReadWriteTimeout * time.Second
+	dialer := &net.Dialer{Timeout: ossConnectTimeout * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext:           limiter.dialContext(deadlineDial(dialer, timeout)),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
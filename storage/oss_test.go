@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"backup-go/config"
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+func TestMultipartConfig_PartSizeFor(t *testing.T) {
+	mc := newMultipartConfig(config.OssConfig{PartSize: 10 * 1024 * 1024})
+
+	if got := mc.partSizeFor(1024 * 1024 * 1024); got != 10*1024*1024 {
+		t.Fatalf("1GB file part size %d", got)
+	}
+
+	// 200GB 按 10MB 分片会超过 10000 片，需要放大分片
+	fileSize := int64(200) * 1024 * 1024 * 1024
+	got := mc.partSizeFor(fileSize)
+	if (fileSize+got-1)/got > maxPartCount {
+		t.Fatalf("200GB file part size %d produce too many parts", got)
+	}
+}
+
+// fakeOSS 进程内的最小 OSS 实现，通过 IP 访问时 SDK 使用 path style，只支持普通上传、分片上传、
+// 列出分片上传和 HEAD
+type fakeOSS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*fakeOSSUpload
+	nextID  int
+	// failParts 上传这些分片时返回 500，用于模拟上传中断
+	failParts map[int]bool
+	// partUploads 成功上传的分片数
+	partUploads int
+}
+
+type fakeOSSUpload struct {
+	key       string
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+func newFakeOSS(t *testing.T) (*OssClient, *fakeOSS) {
+	fake := &fakeOSS{objects: make(map[string][]byte), uploads: make(map[string]*fakeOSSUpload)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	oc := CreateOSSClient(config.OssConfig{
+		BucketName:      "bucket",
+		AccessKey:       "ak",
+		AccessKeySecret: "sk",
+		Endpoint:        server.URL,
+		PartSize:        100 * 1024,
+		CheckpointDir:   filepath.Join(t.TempDir(), "checkpoint"),
+		Retry:           config.RetryConfig{Attempts: 1},
+	})
+	oc.coolDown = &coolDownStore{path: filepath.Join(t.TempDir(), "cooldown.json")}
+	return oc, fake
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeOSSUpload{key: key, parts: make(map[int][]byte), initiated: time.Now()}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, key, id)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		upload, ok := f.uploads[uploadID]
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		body, err := io.ReadAll(r.Body)
+		if !ok || err != nil || f.failParts[number] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		upload.parts[number] = body
+		f.partUploads++
+		w.Header().Set("ETag", `"`+etag(body)+`"`)
+
+	case r.Method == http.MethodPost && uploadID != "":
+		upload, ok := f.uploads[uploadID]
+		var req struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if !ok || xml.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body []byte
+		for _, part := range req.Parts {
+			body = append(body, upload.parts[part.PartNumber]...)
+		}
+		f.objects[upload.key] = body
+		delete(f.uploads, uploadID)
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>%s</Key><ETag>"%s-%d"</ETag></CompleteMultipartUploadResult>`,
+			upload.key, etag(body), len(req.Parts))
+
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		fmt.Fprint(w, `<ListMultipartUploadsResult><Bucket>bucket</Bucket><IsTruncated>false</IsTruncated>`)
+		for id, upload := range f.uploads {
+			fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>`,
+				upload.key, id, upload.initiated.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", `"`+etag(body)+`"`)
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Header().Set("ETag", `"`+etag(body)+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestOssClientPutStream(t *testing.T) {
+	oc, fake := newFakeOSS(t)
+
+	// 超过一个分片大小，按最小分片 100KB 分为 3 片
+	content := make([]byte, 250*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := oc.PutStream("app_2024_01_01.zip", bytes.NewReader(content), 0, PutOptions{}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.objects["app_2024_01_01.zip"], content) {
+		t.Fatalf("object size %d, want %d", len(fake.objects["app_2024_01_01.zip"]), len(content))
+	}
+	info, err := oc.Stat("app_2024_01_01.zip")
+	if err != nil || info.Size != int64(len(content)) {
+		t.Fatalf("stat got %+v, %v", info, err)
+	}
+
+	// reader 出错或分片上传失败时取消分片上传，不留下对象
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(content)
+		pw.CloseWithError(errors.New("zip failed"))
+	}()
+	if err := oc.PutStream("app_2024_01_02.zip", pr, 0, PutOptions{}, func(string) {}); err == nil {
+		t.Fatal("put stream should fail when reader fails")
+	}
+	fake.failParts = map[int]bool{2: true}
+	if err := oc.PutStream("app_2024_01_03.zip", bytes.NewReader(content), 0, PutOptions{}, func(string) {}); err == nil {
+		t.Fatal("put stream should fail when upload part fails")
+	}
+	if len(fake.objects) != 1 || len(fake.uploads) != 0 {
+		t.Fatalf("objects = %d, uploads = %d after failed stream", len(fake.objects), len(fake.uploads))
+	}
+}
+
+func TestOssClientUploadError(t *testing.T) {
+	oc, fake := newFakeOSS(t)
+	oc.multipart.threshold = 100 * 1024
+
+	file := filepath.Join(t.TempDir(), "app.zip")
+	if err := os.WriteFile(file, make([]byte, 150*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 没有配置 fast bucket 时返回 slow bucket 的错误
+	fake.failParts = map[int]bool{1: true}
+	err := oc.Put("app_2024_01_01.zip", file, PutOptions{}, func(string) {})
+	var serviceErr oss.ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want slow bucket error", err)
+	}
+}
+
+func TestOssClientResumeUpload(t *testing.T) {
+	oc, fake := newFakeOSS(t)
+	oc.multipart.threshold = 200 * 1024
+
+	// 按最小分片 100KB 分为 5 片
+	content := make([]byte, 450*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "app.zip")
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	noop := func(string) {}
+	// interrupt 上传第 4 片时失败，返回留在服务端的分片上传
+	interrupt := func(objKey string) string {
+		t.Helper()
+		fake.failParts = map[int]bool{4: true}
+		defer func() { fake.failParts = nil }()
+		if err := oc.Put(objKey, file, PutOptions{}, noop); err == nil {
+			t.Fatal("put should fail when upload part fails")
+		}
+		for id, upload := range fake.uploads {
+			if upload.key == objKey {
+				return id
+			}
+		}
+		t.Fatalf("no multipart upload left for %s", objKey)
+		return ""
+	}
+
+	// 断点记录只在磁盘上，再次上传同一文件时只上传剩余的分片，完成同一个分片上传
+	uploadID := interrupt("app_2024_01_01.zip")
+	done := len(fake.uploads[uploadID].parts)
+	if done <= 0 || done >= 5 {
+		t.Fatalf("parts uploaded before interrupt = %d", done)
+	}
+	fake.partUploads = 0
+	if err := oc.Put("app_2024_01_01.zip", file, PutOptions{}, noop); err != nil {
+		t.Fatal(err)
+	}
+	// 中断时仍在上传的分片可能已到达服务端但没有记入断点，续传时重新上传
+	if fake.partUploads >= 5 || !bytes.Equal(fake.objects["app_2024_01_01.zip"], content) {
+		t.Fatalf("resumed parts = %d, done before = %d, object size %d", fake.partUploads, done, len(fake.objects["app_2024_01_01.zip"]))
+	}
+	if _, ok := fake.uploads[uploadID]; ok {
+		t.Fatal("resumed upload should be completed")
+	}
+
+	// 文件变化后断点记录失效，取消旧的分片上传再重新上传
+	uploadID = interrupt("app_2024_01_02.zip")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := oc.Put("app_2024_01_02.zip", file, PutOptions{}, noop); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.uploads) != 0 {
+		t.Fatalf("uploads = %d after stale checkpoint, want 0", len(fake.uploads))
+	}
+
+	// 放弃续传时取消分片上传并删除断点记录
+	interrupt("app_2024_01_03.zip")
+	if err := oc.AbortUpload("app_2024_01_03.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(oc.multipart.checkpointDir, "*.cp")); len(fake.uploads) != 0 || len(matches) != 0 {
+		t.Fatalf("uploads = %d, checkpoints = %v after abort", len(fake.uploads), matches)
+	}
+
+	// 清理时取消过期的分片上传，断点记录仍在使用的保留
+	uploadID = interrupt("app_2024_01_04.zip")
+	old := time.Now().Add(-8 * 24 * time.Hour)
+	fake.uploads[uploadID].initiated = old
+	fake.uploads["leaked"] = &fakeOSSUpload{key: "app_2024_01_05.zip", parts: make(map[int][]byte), initiated: old}
+	aborted, err := oc.CleanupUploads(time.Now().Add(-7 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.uploads[uploadID]; aborted != 1 || !ok || len(fake.uploads) != 1 {
+		t.Fatalf("aborted = %d, uploads = %d, in use kept = %v", aborted, len(fake.uploads), ok)
+	}
+}
+
+func TestOssHTTPClient(t *testing.T) {
+	var redirected bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	t.Cleanup(target.Close)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+r.URL.Path, http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+
+	// 限速时使用自定义 client，仍然不跟随重定向，超时与 SDK 一致
+	rateLimit := &config.RateLimitConfig{Limit: 10 * 1024 * 1024}
+	oc := CreateOSSClient(config.OssConfig{
+		BucketName:      "bucket",
+		AccessKey:       "ak",
+		AccessKeySecret: "sk",
+		Endpoint:        server.URL,
+		RateLimit:       rateLimit,
+	})
+	if _, err := oc.Stat("app_2024_01_01.zip"); err == nil || redirected {
+		t.Fatalf("stat err = %v, redirected = %v", err, redirected)
+	}
+
+	transport := ossHTTPClient(newBandwidthLimiter(rateLimit)).Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 3*time.Hour || transport.IdleConnTimeout != 3*time.Hour {
+		t.Errorf("response header timeout = %v, idle timeout = %v", transport.ResponseHeaderTimeout, transport.IdleConnTimeout)
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"backup-go/config"
+	"backup-go/utils"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// minRateBurst 令牌桶最小容量，避免限速很低时单次写入被拆得过碎
+	minRateBurst = 32 * 1024
+	// throughputInterval 上传中报告实际速度的间隔
+	throughputInterval = 30 * time.Second
+)
+
+// bandwidthLimiter 上传限速，同一个存储客户端的所有连接共享，按时间段切换限速值；
+// nil 表示不限速，所有方法都可以在 nil 上调用
+type bandwidthLimiter struct {
+	mu           sync.Mutex
+	limiter      *rate.Limiter
+	current      int64
+	defaultLimit int64
+	windows      []rateWindow
+	now          func() time.Time
+	written      atomic.Int64
+}
+
+// rateWindow 一天中的时间段，start、end 为距离零点的时长
+type rateWindow struct {
+	start time.Duration
+	end   time.Duration
+	limit int64
+}
+
+func newBandwidthLimiter(conf *config.RateLimitConfig) *bandwidthLimiter {
+	if conf == nil || (conf.Limit <= 0 && len(conf.Windows) <= 0) {
+		return nil
+	}
+
+	l := &bandwidthLimiter{
+		limiter:      rate.NewLimiter(rate.Inf, minRateBurst),
+		defaultLimit: int64(conf.Limit),
+		now:          time.Now,
+	}
+	for _, w := range conf.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			panic(fmt.Sprintf("invalid rate limit window start %q: %v", w.Start, err))
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			panic(fmt.Sprintf("invalid rate limit window end %q: %v", w.End, err))
+		}
+		l.windows = append(l.windows, rateWindow{start: start, end: end, limit: int64(w.Limit)})
+	}
+
+	return l
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w rateWindow) contains(d time.Duration) bool {
+	if w.start <= w.end {
+		return d >= w.start && d < w.end
+	}
+	// 跨过午夜，如 22:00-06:00
+	return d >= w.start || d < w.end
+}
+
+// limitAt 返回 t 时刻的限速，0 表示不限速
+func (l *bandwidthLimiter) limitAt(t time.Time) int64 {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, w := range l.windows {
+		if w.contains(d) {
+			return w.limit
+		}
+	}
+	return l.defaultLimit
+}
+
+// refresh 按当前时间段更新令牌桶，返回当前限速
+func (l *bandwidthLimiter) refresh() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.limitAt(l.now())
+	if limit == l.current {
+		return limit
+	}
+
+	l.current = limit
+	if limit <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+	} else {
+		l.limiter.SetLimit(rate.Limit(limit))
+		l.limiter.SetBurst(int(max(limit, minRateBurst)))
+	}
+	return limit
+}
+
+// wait 写入 n 字节前等待令牌
+func (l *bandwidthLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.written.Add(int64(n))
+	if l.refresh() <= 0 {
+		return
+	}
+
+	for n > 0 {
+		chunk := min(n, l.limiter.Burst())
+		l.limiter.WaitN(context.Background(), chunk)
+		n -= chunk
+	}
+}
+
+// report 上传期间定时通知实际速度和当前限速，返回停止函数
+func (l *bandwidthLimiter) report(name string, noticeFunc UploadNoticeFunc) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(throughputInterval)
+		defer ticker.Stop()
+
+		lastBytes, lastTime := l.written.Load(), time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				bytes := l.written.Load()
+				speed := float64(bytes-lastBytes) / now.Sub(lastTime).Seconds()
+				lastBytes, lastTime = bytes, now
+
+				limit := "unlimited"
+				if current := l.refresh(); current > 0 {
+					limit = utils.FormatBytes(current) + "/s"
+				}
+				noticeFunc(fmt.Sprintf("use 【%s】 throughput %s/s, limit %s", name, utils.FormatBytes(int64(speed)), limit))
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// reader 返回限速读取的 reader，用于本地目录等不经过网络连接的存储
+func (l *bandwidthLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{Reader: r, limiter: l}
+}
+
+// dialContext 包装拨号函数，建立的连接写入时限速
+func (l *bandwidthLimiter) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if l == nil {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &throttledConn{Conn: conn, limiter: l}, nil
+	}
+}
+
+// deadlineDial 返回的连接每次读写前设置超时，连接长时间没有数据收发时失败，不限制整个请求的时长
+func deadlineDial(dialer *net.Dialer, timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &deadlineConn{Conn: conn, timeout: timeout}, nil
+	}
+}
+
+// deadlineConn 每次读写前设置超时，限速等待不计入超时。http.Transport 在发送请求体的同时等待响应，
+// 写入时也延后读超时，上传时间超过超时不会导致等待响应的读取失败
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// transport 返回写入限速的 http.Transport，其他参数与 http.DefaultTransport 一致
+func (l *bandwidthLimiter) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = l.dialContext(dialer.DialContext)
+	return transport
+}
+
+type throttledConn struct {
+	net.Conn
+	limiter *bandwidthLimiter
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	c.limiter.wait(len(p))
+	return c.Conn.Write(p)
+}
+
+type throttledReader struct {
+	io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
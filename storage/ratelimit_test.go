@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"backup-go/config"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterLimitAt(t *testing.T) {
+	l := newBandwidthLimiter(&config.RateLimitConfig{
+		Limit: 10 << 20,
+		Windows: []config.RateWindowConfig{
+			{Start: "09:00", End: "18:00", Limit: 1 << 20},
+			{Start: "22:00", End: "06:00", Limit: 0},
+		},
+	})
+
+	day := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", "2024-01-01 "+clock, time.Local)
+		return t
+	}
+	for clock, want := range map[string]int64{
+		"08:59": 10 << 20,
+		"09:00": 1 << 20,
+		"17:59": 1 << 20,
+		"18:00": 10 << 20,
+		"23:30": 0,
+		"05:59": 0,
+		"06:00": 10 << 20,
+	} {
+		if got := l.limitAt(day(clock)); got != want {
+			t.Errorf("limit at %s got %d, want %d", clock, got, want)
+		}
+	}
+
+	if newBandwidthLimiter(&config.RateLimitConfig{}) != nil {
+		t.Fatal("empty rate limit should not create limiter")
+	}
+}
+
+func TestBandwidthLimiterReader(t *testing.T) {
+	l := newBandwidthLimiter(&config.RateLimitConfig{Limit: 64 << 10})
+
+	// 首秒可以用满令牌桶，剩余 32KB 需要等待约 0.5s
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.reader(bytes.NewReader(make([]byte, 96<<10))))
+	if err != nil || n != 96<<10 {
+		t.Fatalf("copy got %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("read 96KB at 64KB/s took %v, should be throttled", elapsed)
+	}
+
+	var nilLimiter *bandwidthLimiter
+	if r := bytes.NewReader(nil); nilLimiter.reader(r) != r {
+		t.Fatal("nil limiter should not wrap reader")
+	}
+}
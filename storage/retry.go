@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"backup-go/config"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 10 * time.Second
+	defaultRetryMaxBackoff = 5 * time.Minute
+	defaultRetryJitter     = 0.2
+)
+
+// retryPolicy 上传重试策略，退避时间按 backoff * 2^n 增长，不超过 maxBackoff
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	retryable  func(error) bool
+	sleep      func(time.Duration)
+}
+
+func newRetryPolicy(conf config.RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		attempts:   conf.Attempts,
+		backoff:    conf.Backoff,
+		maxBackoff: conf.MaxBackoff,
+		jitter:     defaultRetryJitter,
+		retryable:  isRetryableError,
+		sleep:      time.Sleep,
+	}
+
+	if policy.attempts <= 0 {
+		policy.attempts = defaultRetryAttempts
+	}
+	if policy.backoff <= 0 {
+		policy.backoff = defaultRetryBackoff
+	}
+	if policy.maxBackoff <= 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+	if conf.Jitter != nil && *conf.Jitter >= 0 && *conf.Jitter <= 1 {
+		policy.jitter = *conf.Jitter
+	}
+
+	return policy
+}
+
+// Do 执行 fn，遇到可重试的错误时按策略等待后重试
+func (p retryPolicy) Do(name string, noticeFunc UploadNoticeFunc, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= p.attempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if !p.retryable(err) {
+			noticeFunc(fmt.Sprintf("use 【%s】 upload failed with non-retryable error: %v", name, err))
+			return err
+		}
+		if attempt >= p.attempts {
+			break
+		}
+
+		wait := p.delay(attempt)
+		noticeFunc(fmt.Sprintf("use 【%s】 upload failed (attempt %d/%d), retry in %v, error: %v",
+			name, attempt, p.attempts, wait.Round(time.Second), err))
+		p.sleep(wait)
+	}
+
+	return fmt.Errorf("upload failed after %d attempts: %w", p.attempts, err)
+}
+
+// delay 第 attempt 次失败后的等待时间
+func (p retryPolicy) delay(attempt int) time.Duration {
+	wait := p.backoff
+	for i := 1; i < attempt && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, p.maxBackoff)
+
+	// 在 [1-jitter, 1+jitter] 范围内随机抖动，避免多个任务同时重试
+	factor := 1 + p.jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(wait) * factor)
+}
+
+// isRetryableError 判断错误是否为网络抖动、服务端 5xx 等可重试的临时错误
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode >= 500 ||
+			serviceErr.StatusCode == 429 ||
+			serviceErr.Code == "RequestTimeout"
+	}
+
+	var statusErr oss.UnexpectedStatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.Got() >= 500 || statusErr.Got() == 429
+	}
+
+	var crcErr oss.CRCCheckError
+	if errors.As(err, &crcErr) {
+		return true
+	}
+
+	// 本地文件错误重试也无法恢复
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
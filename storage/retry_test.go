@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"backup-go/config"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+func TestRetryPolicy_Do(t *testing.T) {
+	var waits []time.Duration
+	policy := newRetryPolicy(config.RetryConfig{Attempts: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+	policy.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	calls := 0
+	err := policy.Do("SLOW", func(string) {}, func() error {
+		calls++
+		if calls < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expect success on 3rd attempt, got calls %d err %v", calls, err)
+	}
+	if len(waits) != 2 || waits[1] < waits[0] {
+		t.Fatalf("expect growing backoff, got %v", waits)
+	}
+
+	calls = 0
+	err = policy.Do("SLOW", func(string) {}, func() error {
+		calls++
+		return oss.ServiceError{StatusCode: 403, Code: "AccessDenied"}
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("non-retryable error should not retry, calls %d", calls)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{oss.ServiceError{StatusCode: 503}, true},
+		{oss.ServiceError{StatusCode: 404, Code: "NoSuchBucket"}, false},
+		{&fs.PathError{Op: "open", Path: "a.zip", Err: fs.ErrNotExist}, false},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("unknown"), false},
+	}
+	for _, c := range cases {
+		if got := isRetryableError(c.err); got != c.want {
+			t.Errorf("isRetryableError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRetryPolicy_Jitter(t *testing.T) {
+	if policy := newRetryPolicy(config.RetryConfig{}); policy.jitter != defaultRetryJitter {
+		t.Errorf("default jitter = %v, want %v", policy.jitter, defaultRetryJitter)
+	}
+
+	// 显式配置为 0 时关闭抖动，等待时间固定
+	zero := 0.0
+	policy := newRetryPolicy(config.RetryConfig{Backoff: time.Second, Jitter: &zero})
+	for i := 0; i < 10; i++ {
+		if wait := policy.delay(1); wait != time.Second {
+			t.Fatalf("delay with zero jitter = %v, want 1s", wait)
+		}
+	}
+}
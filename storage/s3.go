@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"backup-go/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// S3Client S3 兼容存储（AWS S3、MinIO、Cloudflare R2 等）
+type S3Client struct {
+	client     *minio.Client
+	bucketName string
+	prefix     keyPrefix
+	limiter    *bandwidthLimiter
+}
+
+// minStreamPartSize 流式上传的最小分片大小
+const minStreamPartSize = 16 * 1024 * 1024
+
+var _ StreamStorage = (*S3Client)(nil)
+
+func CreateS3Client(conf config.S3Config) *S3Client {
+	if conf.Endpoint == "" || conf.BucketName == "" {
+		panic("s3 endpoint or bucket_name can not be empty")
+	}
+
+	// endpoint 允许带 scheme，http:// 表示不使用 TLS
+	endpoint := conf.Endpoint
+	secure := true
+	if after, ok := strings.CutPrefix(endpoint, "http://"); ok {
+		endpoint, secure = after, false
+	} else if after, ok := strings.CutPrefix(endpoint, "https://"); ok {
+		endpoint = after
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	lookup := minio.BucketLookupAuto
+	if conf.GetPathStyle() {
+		lookup = minio.BucketLookupPath
+	}
+
+	options := &minio.Options{
+		Creds:        credentials.NewStaticV4(conf.AccessKey, conf.AccessKeySecret, ""),
+		Secure:       secure,
+		Region:       conf.Region,
+		BucketLookup: lookup,
+	}
+	limiter := newBandwidthLimiter(conf.RateLimit)
+	if limiter != nil {
+		options.Transport = limiter.transport()
+	}
+
+	client, err := minio.New(endpoint, options)
+	if err != nil {
+		panic(err)
+	}
+
+	s3Client := &S3Client{
+		client:     client,
+		bucketName: conf.BucketName,
+		prefix:     newKeyPrefix(conf.Prefix),
+		limiter:    limiter,
+	}
+
+	log.Printf("s3 client init done: %s/%s", endpoint, conf.BucketName)
+
+	return s3Client
+}
+
+func (sc *S3Client) GetName() string {
+	return "S3"
+}
+
+func (sc *S3Client) Put(objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket uploading", sc.bucketName))
+	defer sc.limiter.report(sc.bucketName, noticeFunc)()
+	putOpts, err := s3PutOptions(opts)
+	if err != nil {
+		return err
+	}
+	// minio 按分片计算并发送 Content-MD5
+	putOpts.SendContentMd5 = opts.Checksum != nil
+
+	_, err = sc.client.FPutObject(context.Background(), sc.bucketName, sc.prefix.full(objKey), filePath, putOpts)
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket upload failed, error: %v", sc.bucketName, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket upload success", sc.bucketName))
+	return nil
+}
+
+func (sc *S3Client) PutStream(objKey string, reader io.Reader, sizeHint int64, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	putOpts, err := s3PutOptions(opts)
+	if err != nil {
+		return err
+	}
+	// 大小未知时 minio 默认按 5TB 计算分片，每个分片需要缓存 512MB，这里按预估大小选择分片
+	partSize := max(uint64(sizeHint)/(maxPartCount-1)+1, minStreamPartSize)
+	putOpts.PartSize = partSize
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket streaming upload, part size %d", sc.bucketName, partSize))
+	defer sc.limiter.report(sc.bucketName, noticeFunc)()
+	_, err = sc.client.PutObject(context.Background(), sc.bucketName, sc.prefix.full(objKey), reader, -1, putOpts)
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 bucket upload failed, error: %v", sc.bucketName, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 bucket upload success", sc.bucketName))
+	return nil
+}
+
+// s3PutOptions 转换存储类型、加密、ACL、元数据和标签
+func s3PutOptions(opts PutOptions) (minio.PutObjectOptions, error) {
+	putOpts := minio.PutObjectOptions{
+		StorageClass: opts.StorageClass,
+		UserTags:     opts.Tags,
+		UserMetadata: make(map[string]string),
+	}
+
+	for k, v := range opts.Metadata {
+		putOpts.UserMetadata[k] = metadataValue(v)
+	}
+	if opts.ACL != "" {
+		putOpts.UserMetadata["x-amz-acl"] = opts.ACL
+	}
+
+	switch strings.ToUpper(opts.Encryption) {
+	case "":
+	case "AES256":
+		putOpts.ServerSideEncryption = encrypt.NewSSE()
+	case "KMS", "AWS:KMS":
+		sse, err := encrypt.NewSSEKMS(opts.KMSKeyID, nil)
+		if err != nil {
+			return putOpts, err
+		}
+		putOpts.ServerSideEncryption = sse
+	default:
+		return putOpts, fmt.Errorf("unsupported s3 encryption %s", opts.Encryption)
+	}
+
+	return putOpts, nil
+}
+
+func (sc *S3Client) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for object := range sc.client.ListObjects(context.Background(), sc.bucketName, minio.ListObjectsOptions{
+		Prefix:    sc.prefix.full(prefix),
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		info := toObjectInfo(object)
+		info.Key = sc.prefix.trim(info.Key)
+		objects = append(objects, info)
+	}
+
+	return objects, nil
+}
+
+func (sc *S3Client) Delete(keys []string) ([]string, error) {
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, key := range keys {
+			objectsCh <- minio.ObjectInfo{Key: sc.prefix.full(key)}
+		}
+	}()
+
+	failed := make(map[string]error)
+	for e := range sc.client.RemoveObjects(context.Background(), sc.bucketName, objectsCh, minio.RemoveObjectsOptions{}) {
+		failed[sc.prefix.trim(e.ObjectName)] = e.Err
+	}
+
+	// 按 keys 的顺序返回所有失败
+	var deleted []string
+	var errs []error
+	for _, key := range keys {
+		if err, ok := failed[key]; ok {
+			errs = append(errs, fmt.Errorf("delete %s failed: %w", key, err))
+		} else {
+			deleted = append(deleted, key)
+		}
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+func (sc *S3Client) Get(objKey, filePath string) error {
+	return sc.client.FGetObject(context.Background(), sc.bucketName, sc.prefix.full(objKey), filePath, minio.GetObjectOptions{})
+}
+
+func (sc *S3Client) Stat(objKey string) (*ObjectInfo, error) {
+	object, err := sc.client.StatObject(context.Background(), sc.bucketName, sc.prefix.full(objKey), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	info := toObjectInfo(object)
+	info.Key = objKey
+	return &info, nil
+}
+
+func toObjectInfo(object minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          object.Key,
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		ETag:         strings.Trim(object.ETag, `"`),
+		MD5:          s3ContentMD5(object),
+	}
+}
+
+// s3ContentMD5 SSE-KMS 加密的对象 ETag 不是内容 MD5
+func s3ContentMD5(object minio.ObjectInfo) string {
+	if strings.HasPrefix(object.Metadata.Get("X-Amz-Server-Side-Encryption"), "aws:kms") {
+		return ""
+	}
+	return etagMD5(object.ETag)
+}
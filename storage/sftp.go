@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"backup-go/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPStorage 通过 SFTP 上传到只开放 SSH 的服务器
+type SFTPStorage struct {
+	addr      string
+	dir       string
+	sshConfig *ssh.ClientConfig
+	limiter   *bandwidthLimiter
+}
+
+var _ StreamStorage = (*SFTPStorage)(nil)
+
+func CreateSFTPStorage(conf config.SFTPConfig) *SFTPStorage {
+	if conf.Host == "" || conf.User == "" || conf.Dir == "" {
+		panic("sftp host, user or dir can not be empty")
+	}
+
+	var auths []ssh.AuthMethod
+	if conf.KeyFile != "" {
+		signer, err := loadPrivateKey(conf.KeyFile, conf.KeyPassphrase)
+		if err != nil {
+			panic(err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if conf.Password != "" {
+		auths = append(auths, ssh.Password(conf.Password))
+	}
+	if len(auths) <= 0 {
+		panic("sftp key_file or password must be set")
+	}
+
+	hostKeyCallback, err := getHostKeyCallback(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	port := conf.Port
+	if port == 0 {
+		port = 22
+	}
+
+	ss := &SFTPStorage{
+		addr:    net.JoinHostPort(conf.Host, strconv.Itoa(port)),
+		dir:     path.Clean(conf.Dir),
+		limiter: newBandwidthLimiter(conf.RateLimit),
+		sshConfig: &ssh.ClientConfig{
+			User:            conf.User,
+			Auth:            auths,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}
+
+	log.Printf("sftp storage init done: %s@%s:%s", conf.User, ss.addr, ss.dir)
+
+	return ss
+}
+
+func loadPrivateKey(keyFile, passphrase string) (ssh.Signer, error) {
+	key, err := os.ReadFile(expandHome(keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("read key file failed: %w", err)
+	}
+
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(key)
+}
+
+func getHostKeyCallback(conf config.SFTPConfig) (ssh.HostKeyCallback, error) {
+	if conf.InsecureIgnoreHostKey {
+		log.Printf("sftp %s host key checking disabled", conf.Host)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	knownHostsFile := conf.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = "~/.ssh/known_hosts"
+	}
+
+	callback, err := knownhosts.New(expandHome(knownHostsFile))
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts failed: %w", err)
+	}
+	return callback, nil
+}
+
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
+
+func (ss *SFTPStorage) GetName() string {
+	return "SFTP"
+}
+
+// connect 每次操作建立新连接，备份任务间隔较长，无需保持长连接
+func (ss *SFTPStorage) connect() (*sftp.Client, func(), error) {
+	conn, err := ss.dial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh dial %s failed: %w", ss.addr, err)
+	}
+
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("create sftp client failed: %w", err)
+	}
+
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (ss *SFTPStorage) Put(objKey, filePath string, _ PutOptions, noticeFunc UploadNoticeFunc) error {
+	local, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	return ss.PutStream(objKey, local, 0, PutOptions{}, noticeFunc)
+}
+
+func (ss *SFTPStorage) PutStream(objKey string, reader io.Reader, _ int64, _ PutOptions, noticeFunc UploadNoticeFunc) error {
+	noticeFunc(fmt.Sprintf("use 【%s】 uploading", ss.addr))
+	defer ss.limiter.report(ss.addr, noticeFunc)()
+	err := ss.put(objKey, reader)
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 upload failed, error: %v", ss.addr, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 upload success", ss.addr))
+	return nil
+}
+
+func (ss *SFTPStorage) put(objKey string, local io.Reader) error {
+	client, closeFunc, err := ss.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	target := ss.objPath(objKey)
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("mkdir failed: %w", err)
+	}
+
+	// 先上传到临时文件，完成后再重命名，避免中断后留下不完整的备份
+	tmp := target + tmpSuffix
+	remote, err := client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create remote file failed: %w", err)
+	}
+
+	if _, err := remote.ReadFrom(local); err != nil {
+		remote.Close()
+		client.Remove(tmp)
+		return fmt.Errorf("write remote file failed: %w", err)
+	}
+	if err := remote.Close(); err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("close remote file failed: %w", err)
+	}
+
+	if err := client.PosixRename(tmp, target); err != nil {
+		// 服务端不支持 posix-rename 扩展时退化为删除后重命名
+		client.Remove(target)
+		if err := client.Rename(tmp, target); err != nil {
+			client.Remove(tmp)
+			return fmt.Errorf("rename remote file failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// dial 与 ssh.Dial 相同，连接写入时限速
+func (ss *SFTPStorage) dial() (*ssh.Client, error) {
+	dialer := &net.Dialer{Timeout: ss.sshConfig.Timeout}
+	netConn, err := ss.limiter.dialContext(dialer.DialContext)(context.Background(), "tcp", ss.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(netConn, ss.addr, ss.sshConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (ss *SFTPStorage) List(prefix string) ([]ObjectInfo, error) {
+	client, closeFunc, err := ss.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	// 只遍历前缀所在的目录
+	start := path.Join(ss.dir, prefixDir(prefix))
+
+	var objects []ObjectInfo
+	walker := client.Walk(start)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) && walker.Path() == start {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		info := walker.Stat()
+		if info.IsDir() {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), ss.dir), "/")
+		if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, tmpSuffix) {
+			continue
+		}
+
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	return objects, nil
+}
+
+func (ss *SFTPStorage) Delete(keys []string) ([]string, error) {
+	client, closeFunc, err := ss.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	var deleted []string
+	var errs []error
+	for _, key := range keys {
+		if err := client.Remove(ss.objPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+func (ss *SFTPStorage) Get(objKey, filePath string) error {
+	client, closeFunc, err := ss.connect()
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	remote, err := client.Open(ss.objPath(objKey))
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	local, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	if _, err := remote.WriteTo(local); err != nil {
+		return err
+	}
+	return local.Close()
+}
+
+func (ss *SFTPStorage) Stat(objKey string) (*ObjectInfo, error) {
+	client, closeFunc, err := ss.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	info, err := client.Stat(ss.objPath(objKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:          objKey,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (ss *SFTPStorage) objPath(objKey string) string {
+	return path.Join(ss.dir, objKey)
+}
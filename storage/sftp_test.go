@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"backup-go/config"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer 启动进程内的 SFTP 服务，返回监听地址和 host key
+func startSFTPServer(t *testing.T, password string) (string, ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != password {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, serverConfig)
+		}
+	}()
+
+	return listener.Addr().String(), hostKey.PublicKey()
+}
+
+func serveSFTPConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+func TestSFTPStorage(t *testing.T) {
+	addr, hostKey := startSFTPServer(t, "secret")
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	dir := t.TempDir()
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := CreateSFTPStorage(config.SFTPConfig{
+		Host:       host,
+		Port:       port,
+		User:       "backup",
+		Password:   "secret",
+		Dir:        filepath.ToSlash(filepath.Join(dir, "remote")),
+		KnownHosts: knownHostsFile,
+	})
+
+	objects, err := ss.List("")
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("list empty dir got %+v, %v", objects, err)
+	}
+
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, []byte("backup content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"app_2024_01_01.zip", "app_2024_01_02.zip"} {
+		if err := ss.Put(key, src, PutOptions{}, func(string) {}); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	objects, err = ss.List("app_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("list got %+v", objects)
+	}
+
+	info, err := ss.Stat("app_2024_01_01.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("backup content")) {
+		t.Fatalf("stat size %d", info.Size)
+	}
+
+	dst := filepath.Join(dir, "dst.zip")
+	if err := ss.Get("app_2024_01_02.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "backup content" {
+		t.Fatalf("get content %q", b)
+	}
+
+	deleted, err := ss.Delete([]string{"app_2024_01_01.zip"})
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("delete got %v, %v", deleted, err)
+	}
+}
+
+func TestSFTPStorageUnknownHost(t *testing.T) {
+	addr, _ := startSFTPServer(t, "secret")
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := CreateSFTPStorage(config.SFTPConfig{
+		Host:       host,
+		Port:       port,
+		User:       "backup",
+		Password:   "secret",
+		Dir:        "/backup",
+		KnownHosts: knownHostsFile,
+	})
+
+	if _, err := ss.List(""); err == nil {
+		t.Fatal("expect host key verification error")
+	}
+}
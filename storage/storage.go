@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"backup-go/config"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCoolDown = errors.New("fast upload cool down")
+
+	// ErrChecksumMismatch 上传后的对象与本地文件不一致
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+type (
+	// ObjectInfo 存储中的对象信息
+	ObjectInfo struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		ETag         string
+		// MD5、CRC64 存储端记录的内容校验值，不提供时为空
+		MD5   string
+		CRC64 string
+	}
+
+	// Checksum 本地文件的大小和校验值
+	Checksum struct {
+		Size  int64
+		MD5   string // hex
+		CRC64 uint64 // ECMA
+	}
+
+	// PutOptions 上传选项
+	PutOptions struct {
+		// Checksum 设置后上传时携带 Content-MD5，存储端支持时由服务端校验
+		Checksum *Checksum
+		// StorageClass、Encryption、KMSKeyID、ACL、Metadata、Tags 只对 OSS、S3 生效，为空时使用 bucket 默认设置
+		StorageClass string
+		Encryption   string
+		KMSKeyID     string
+		ACL          string
+		Metadata     map[string]string
+		Tags         map[string]string
+	}
+
+	UploadNoticeFunc func(string)
+
+	// NamedStorage 带配置名称的存储目标
+	NamedStorage struct {
+		Name string
+		Storage
+	}
+)
+
+// Storage 备份存储后端，不同的存储目标实现该接口即可接入上传和历史清理流程
+type Storage interface {
+	// Put 上传本地文件到 objKey
+	Put(objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) error
+
+	// List 列出 prefix 下的所有对象
+	List(prefix string) ([]ObjectInfo, error)
+
+	// Delete 批量删除对象，返回已删除的 key
+	Delete(keys []string) ([]string, error)
+
+	// Get 下载对象到本地文件
+	Get(objKey, filePath string) error
+
+	// Stat 获取对象信息
+	Stat(objKey string) (*ObjectInfo, error)
+
+	// GetName 获取存储名称
+	GetName() string
+}
+
+// StreamStorage 支持流式上传的存储，数据边生成边上传，本地不保存完整文件
+type StreamStorage interface {
+	Storage
+
+	// PutStream 读取 reader 直到 EOF 并上传到 objKey，sizeHint 为预估大小，用于选择分片大小；
+	// reader 返回错误时放弃本次上传，不留下不完整的对象
+	PutStream(objKey string, reader io.Reader, sizeHint int64, opts PutOptions, noticeFunc UploadNoticeFunc) error
+}
+
+// ResumableStorage 支持断点续传的存储，上传失败后用同一文件再次 Put 时从已完成的部分继续
+type ResumableStorage interface {
+	Storage
+
+	// AbortUpload 放弃 objKey 未完成的上传，取消服务端的分片上传并删除断点记录
+	AbortUpload(objKey string) error
+	// CleanupUploads 取消发起时间早于 before 且没有断点记录在使用的分片上传，删除 before 之前的断点记录，
+	// 返回取消的上传数
+	CleanupUploads(before time.Time) (int, error)
+}
+
+// HasError 判断上传结果是否为真正的失败（冷却期不算失败）
+func HasError(err error) bool {
+	return err != nil && !errors.Is(err, ErrCoolDown)
+}
+
+// HasCoolDownError 判断上传是否因冷却期被延迟
+func HasCoolDownError(err error) bool {
+	return errors.Is(err, ErrCoolDown)
+}
+
+// ContentMD5 返回 base64 编码的 MD5，用于 Content-MD5 请求头
+func (c *Checksum) ContentMD5() string {
+	if c == nil {
+		return ""
+	}
+	sum, err := hex.DecodeString(c.MD5)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// Verified 记录 Verify 实际比对过的项目，零值表示没有校验
+type Verified struct {
+	Size  bool
+	MD5   bool
+	CRC64 bool
+}
+
+// Merge 合并多个对象的校验结果，只保留每个对象都比对过的项目
+func (v Verified) Merge(other Verified) Verified {
+	if v == (Verified{}) {
+		return other
+	}
+	return Verified{Size: v.Size && other.Size, MD5: v.MD5 && other.MD5, CRC64: v.CRC64 && other.CRC64}
+}
+
+// Verify 读取已上传对象的信息，与本地文件的大小和校验值比对，存储端不提供的校验值跳过，返回实际比对过的项目
+func Verify(st Storage, objKey string, checksum *Checksum) (Verified, error) {
+	if checksum == nil {
+		return Verified{}, nil
+	}
+
+	info, err := st.Stat(objKey)
+	if err != nil {
+		return Verified{}, fmt.Errorf("stat uploaded object failed: %w", err)
+	}
+
+	if info.Size != checksum.Size {
+		return Verified{}, fmt.Errorf("%w: size %d, want %d", ErrChecksumMismatch, info.Size, checksum.Size)
+	}
+	if info.MD5 != "" && !strings.EqualFold(info.MD5, checksum.MD5) {
+		return Verified{}, fmt.Errorf("%w: md5 %s, want %s", ErrChecksumMismatch, info.MD5, checksum.MD5)
+	}
+	if want := strconv.FormatUint(checksum.CRC64, 10); info.CRC64 != "" && info.CRC64 != want {
+		return Verified{}, fmt.Errorf("%w: crc64 %s, want %s", ErrChecksumMismatch, info.CRC64, want)
+	}
+	return Verified{Size: true, MD5: info.MD5 != "", CRC64: info.CRC64 != ""}, nil
+}
+
+// prefixDir 返回 prefix 中最后一个 / 及之前的目录部分，目录型存储从该目录开始遍历
+func prefixDir(prefix string) string {
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return prefix[:i+1]
+	}
+	return ""
+}
+
+// metadataValue 元数据通过 http 头传输，只能是可见 ASCII 字符，其他情况按 URL 编码
+func metadataValue(value string) string {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			return url.QueryEscape(value)
+		}
+	}
+	return value
+}
+
+// etagMD5 ETag 为普通上传生成的 MD5 时返回该值，分片上传等情况返回空
+func etagMD5(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return strings.ToLower(etag)
+}
+
+// CreateStorage 根据配置创建对应类型的存储
+func CreateStorage(sc config.StorageConfig) Storage {
+	switch sc.Type() {
+	case config.StorageOSS:
+		return CreateOSSClient(*sc.OSS)
+	case config.StorageS3:
+		return CreateS3Client(*sc.S3)
+	case config.StorageLocal:
+		return CreateLocalStorage(*sc.Local)
+	case config.StorageSFTP:
+		return CreateSFTPStorage(*sc.SFTP)
+	case config.StorageWebDAV:
+		return CreateWebDAVStorage(*sc.WebDAV)
+	default:
+		panic("storage config must set exactly one type")
+	}
+}
+
+var (
+	storageCache   = make(map[string]Storage)
+	storageCacheMu sync.Mutex
+)
+
+// GetStorage 按配置复用存储客户端，配置相同的任务共享同一个客户端
+func GetStorage(sc config.StorageConfig) Storage {
+	key := storageCacheKey(sc)
+
+	storageCacheMu.Lock()
+	defer storageCacheMu.Unlock()
+
+	if st, ok := storageCache[key]; ok {
+		return st
+	}
+
+	st := CreateStorage(sc)
+	storageCache[key] = st
+	return st
+}
+
+// storageCacheKey 按配置内容生成缓存 key，指针字段按指向的值比较，内容相同的配置共用客户端
+func storageCacheKey(sc config.StorageConfig) string {
+	var conf any
+	switch sc.Type() {
+	case config.StorageOSS:
+		conf = sc.OSS
+	case config.StorageS3:
+		conf = sc.S3
+	case config.StorageLocal:
+		conf = sc.Local
+	case config.StorageSFTP:
+		conf = sc.SFTP
+	case config.StorageWebDAV:
+		conf = sc.WebDAV
+	default:
+		return ""
+	}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		panic(fmt.Sprintf("marshal storage config failed: %v", err))
+	}
+	return sc.Type() + ":" + string(b)
+}
+
+// keyPrefix 对象 key 前缀，用于多个任务或主机共享同一个 bucket
+type keyPrefix string
+
+func newKeyPrefix(prefix string) keyPrefix {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return keyPrefix(prefix + "/")
+}
+
+// full 返回带前缀的完整 key
+func (p keyPrefix) full(key string) string {
+	return string(p) + key
+}
+
+// trim 去掉完整 key 中的前缀
+func (p keyPrefix) trim(key string) string {
+	return strings.TrimPrefix(key, string(p))
+}
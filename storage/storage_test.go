@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"backup-go/config"
+	"testing"
+)
+
+func TestStorageCacheKey(t *testing.T) {
+	on, otherOn, off := true, true, false
+	sc := config.S3Config{BucketName: "bucket", PathStyle: &on}
+
+	// 指针字段按值比较，内容相同的配置共用客户端
+	other := sc
+	other.PathStyle = &otherOn
+	if storageCacheKey(config.StorageConfig{S3: &other}) != storageCacheKey(config.StorageConfig{S3: &sc}) {
+		t.Error("equal configs should share cache key")
+	}
+	other.PathStyle = &off
+	if storageCacheKey(config.StorageConfig{S3: &other}) == storageCacheKey(config.StorageConfig{S3: &sc}) {
+		t.Error("different configs should not share cache key")
+	}
+}
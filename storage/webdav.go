@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"backup-go/config"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAVStorage WebDAV 存储，适用于 Nextcloud 等
+type WebDAVStorage struct {
+	baseURL    *url.URL
+	user       string
+	password   string
+	httpClient http.Client
+	limiter    *bandwidthLimiter
+}
+
+var _ StreamStorage = (*WebDAVStorage)(nil)
+
+// webdavReadWriteTimeout 连接上没有数据收发的最长时间，包括上传完成后等待服务端处理的时间
+var webdavReadWriteTimeout = time.Hour
+
+func CreateWebDAVStorage(conf config.WebDAVConfig) *WebDAVStorage {
+	if conf.URL == "" {
+		panic("webdav url can not be empty")
+	}
+
+	baseURL, err := url.Parse(conf.URL)
+	if err != nil {
+		panic(err)
+	}
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		baseURL.Path += "/"
+	}
+
+	limiter := newBandwidthLimiter(conf.RateLimit)
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	ws := &WebDAVStorage{
+		baseURL:  baseURL,
+		user:     conf.User,
+		password: conf.Password,
+		// 不限制整个请求的时长，大文件上传可能持续数小时，只在连接长时间没有数据收发时失败
+		httpClient: http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				DialContext:     limiter.dialContext(deadlineDial(dialer, webdavReadWriteTimeout)),
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify},
+				IdleConnTimeout: 90 * time.Second,
+			},
+		},
+		limiter: limiter,
+	}
+
+	log.Printf("webdav storage init done: %s", baseURL.Redacted())
+
+	return ws
+}
+
+func (ws *WebDAVStorage) GetName() string {
+	return "WebDAV"
+}
+
+func (ws *WebDAVStorage) Put(objKey, filePath string, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return ws.putWithNotice(objKey, file, info.Size(), opts, noticeFunc)
+}
+
+func (ws *WebDAVStorage) PutStream(objKey string, reader io.Reader, _ int64, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	return ws.putWithNotice(objKey, reader, -1, opts, noticeFunc)
+}
+
+func (ws *WebDAVStorage) putWithNotice(objKey string, reader io.Reader, size int64, opts PutOptions, noticeFunc UploadNoticeFunc) error {
+	noticeFunc(fmt.Sprintf("use 【%s】 uploading", ws.baseURL.Host))
+	defer ws.limiter.report(ws.baseURL.Host, noticeFunc)()
+	err := ws.put(objKey, reader, size, opts)
+	if err != nil {
+		noticeFunc(fmt.Sprintf("use 【%s】 upload failed, error: %v", ws.baseURL.Host, err))
+		return err
+	}
+
+	noticeFunc(fmt.Sprintf("use 【%s】 upload success", ws.baseURL.Host))
+	return nil
+}
+
+// put 上传 reader 中的数据，size 未知时传 -1
+func (ws *WebDAVStorage) put(objKey string, reader io.Reader, size int64, opts PutOptions) error {
+	if err := ws.mkdirAll(path.Dir(objKey)); err != nil {
+		return err
+	}
+
+	// 先上传到临时对象，完成后再移动到目标位置，reader 出错或中断时不留下不完整的备份
+	tmp := objKey + tmpSuffix
+	if err := ws.putObject(tmp, reader, size, opts); err != nil {
+		ws.Delete([]string{tmp})
+		return err
+	}
+	if err := ws.move(tmp, objKey); err != nil {
+		ws.Delete([]string{tmp})
+		return err
+	}
+	return nil
+}
+
+func (ws *WebDAVStorage) putObject(key string, reader io.Reader, size int64, opts PutOptions) error {
+	// 包装 reader 隐藏文件类型，让 http 客户端使用 chunked 编码流式发送
+	req, err := ws.newRequest(http.MethodPut, key, struct{ io.Reader }{reader})
+	if err != nil {
+		return err
+	}
+	req.ContentLength = -1
+	// sabre/dav（Nextcloud）通过该头获知 chunked 请求的实际大小
+	if size >= 0 {
+		req.Header.Set("X-Expected-Entity-Length", strconv.FormatInt(size, 10))
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if md5 := opts.Checksum.ContentMD5(); md5 != "" {
+		req.Header.Set("Content-MD5", md5)
+	}
+
+	resp, err := ws.do(req, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// move 把 src 移动到 dst，dst 已存在时覆盖
+func (ws *WebDAVStorage) move(src, dst string) error {
+	req, err := ws.newRequest("MOVE", src, nil)
+	if err != nil {
+		return err
+	}
+	destination := ws.baseURL.JoinPath(dst)
+	destination.User = nil
+	req.Header.Set("Destination", destination.String())
+	req.Header.Set("Overwrite", "T")
+
+	resp, err := ws.do(req, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("move %s to %s failed: %w", src, dst, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// mkdirAll 逐级创建集合，已存在时服务端返回 405
+func (ws *WebDAVStorage) mkdirAll(dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+
+	var current string
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = path.Join(current, part)
+		req, err := ws.newRequest("MKCOL", current+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := ws.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return fmt.Errorf("mkcol %s failed: %w", current, err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (ws *WebDAVStorage) List(prefix string) ([]ObjectInfo, error) {
+	// 只遍历前缀所在的目录
+	start := prefixDir(prefix)
+
+	var objects []ObjectInfo
+	dirs := []string{start}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		entries, err := ws.propfind(dir, "1")
+		if err != nil {
+			if dir == start && errors.Is(err, errWebDAVNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.Key == strings.TrimSuffix(dir, "/") {
+				continue
+			}
+			if entry.isDir {
+				dirs = append(dirs, entry.Key+"/")
+				continue
+			}
+			if strings.HasPrefix(entry.Key, prefix) && !strings.HasSuffix(entry.Key, tmpSuffix) {
+				objects = append(objects, entry.ObjectInfo)
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+func (ws *WebDAVStorage) Delete(keys []string) ([]string, error) {
+	var deleted []string
+	var errs []error
+	for _, key := range keys {
+		req, err := ws.newRequest(http.MethodDelete, key, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := ws.do(req, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete %s failed: %w", key, err))
+			continue
+		}
+		resp.Body.Close()
+		deleted = append(deleted, key)
+	}
+
+	return deleted, errors.Join(errs...)
+}
+
+func (ws *WebDAVStorage) Get(objKey, filePath string) error {
+	req, err := ws.newRequest(http.MethodGet, objKey, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ws.do(req, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (ws *WebDAVStorage) Stat(objKey string) (*ObjectInfo, error) {
+	entries, err := ws.propfind(objKey, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) <= 0 || entries[0].isDir {
+		return nil, fmt.Errorf("%s is not a file", objKey)
+	}
+
+	return &entries[0].ObjectInfo, nil
+}
+
+var errWebDAVNotFound = errors.New("webdav resource not found")
+
+type (
+	webDAVEntry struct {
+		ObjectInfo
+		isDir bool
+	}
+
+	webDAVMultiStatus struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					ContentLength string `xml:"getcontentlength"`
+					LastModified  string `xml:"getlastmodified"`
+					ETag          string `xml:"getetag"`
+					ResourceType  struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/></d:prop></d:propfind>`
+
+func (ws *WebDAVStorage) propfind(key, depth string) ([]webDAVEntry, error) {
+	req, err := ws.newRequest("PROPFIND", key, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := ws.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decode propfind response failed: %w", err)
+	}
+
+	var entries []webDAVEntry
+	for _, r := range ms.Responses {
+		hrefURL, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("parse href %s failed: %w", r.Href, err)
+		}
+		entryKey := strings.Trim(strings.TrimPrefix(hrefURL.Path, ws.baseURL.Path), "/")
+
+		entry := webDAVEntry{ObjectInfo: ObjectInfo{Key: entryKey}}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			prop := ps.Prop
+			entry.isDir = prop.ResourceType.Collection != nil
+			if prop.ContentLength != "" {
+				entry.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			}
+			if prop.LastModified != "" {
+				entry.LastModified, _ = http.ParseTime(prop.LastModified)
+			}
+			entry.ETag = strings.Trim(prop.ETag, `"`)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (ws *WebDAVStorage) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	u := ws.baseURL.JoinPath(key)
+	if strings.HasSuffix(key, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if ws.user != "" {
+		req.SetBasicAuth(ws.user, ws.password)
+	}
+	return req, nil
+}
+
+// do 发送请求，状态码不在 expected 中时返回错误
+func (ws *WebDAVStorage) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := ws.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errWebDAVNotFound
+	}
+	return nil, fmt.Errorf("%s %s unexpected status %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"backup-go/config"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStorage(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "dav")
+	if err := os.MkdirAll(filepath.Join(root, "backup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunked bool
+	handler := &webdav.Handler{
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPut && len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
+			chunked = true
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	ws := CreateWebDAVStorage(config.WebDAVConfig{
+		URL:      server.URL + "/backup",
+		User:     "user",
+		Password: "pass",
+	})
+
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, []byte("backup content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"app_2024_01_01.zip", "a/b/app_2024_01_02.zip"} {
+		if err := ws.Put(key, src, PutOptions{}, func(string) {}); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	if !chunked {
+		t.Fatal("expect chunked PUT")
+	}
+
+	objects, err := ws.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("list got %+v", objects)
+	}
+
+	info, err := ws.Stat("a/b/app_2024_01_02.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len("backup content")) {
+		t.Fatalf("stat size %d", info.Size)
+	}
+
+	dst := filepath.Join(dir, "dst.zip")
+	if err := ws.Get("a/b/app_2024_01_02.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "backup content" {
+		t.Fatalf("get content %q", b)
+	}
+
+	deleted, err := ws.Delete([]string{"app_2024_01_01.zip", "missing.zip"})
+	if err != nil || len(deleted) != 2 {
+		t.Fatalf("delete got %v, %v", deleted, err)
+	}
+
+	objects, err = ws.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "a/b/app_2024_01_02.zip" {
+		t.Fatalf("list after delete got %+v", objects)
+	}
+}
+
+func TestWebDAVStoragePutStream(t *testing.T) {
+	root := t.TempDir()
+	handler := &webdav.Handler{
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	// 串行处理请求，中断的 PUT 在服务端处理完之后才会处理后续请求；puts 在服务端开始处理 PUT 时收到通知
+	var mu sync.Mutex
+	puts := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			puts <- struct{}{}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	ws := CreateWebDAVStorage(config.WebDAVConfig{URL: server.URL})
+
+	for _, content := range []string{"old content", "backup content"} {
+		if err := ws.PutStream("a/app_2024_01_01.zip", strings.NewReader(content), 0, PutOptions{}, func(string) {}); err != nil {
+			t.Fatal(err)
+		}
+		<-puts
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "a", "app_2024_01_01.zip")); string(b) != "backup content" {
+		t.Fatalf("stream content %q", b)
+	}
+
+	// reader 出错时放弃上传，已有的对象不受影响，也不留下临时对象；
+	// 服务端开始处理后 reader 才返回错误，确保部分数据已经写到服务端
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("partial"))
+		<-puts
+		pw.CloseWithError(errors.New("zip failed"))
+	}()
+	if err := ws.PutStream("a/app_2024_01_01.zip", pr, 0, PutOptions{}, func(string) {}); err == nil {
+		t.Fatal("put stream should fail when reader fails")
+	}
+	// 等待服务端处理完中断的请求
+	mu.Lock()
+	defer mu.Unlock()
+	if b, _ := os.ReadFile(filepath.Join(root, "a", "app_2024_01_01.zip")); string(b) != "backup content" {
+		t.Fatalf("content after failed stream %q", b)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "a")); len(entries) != 1 {
+		t.Fatalf("unexpected files after failed stream %v", entries)
+	}
+}
+
+// slowReader 每次读取前等待 delay，模拟压缩速度慢于上传速度的流式上传
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	if len(sr.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(sr.delay)
+	n := copy(p[:min(len(p), 1024)], sr.data)
+	sr.data = sr.data[n:]
+	return n, nil
+}
+
+func TestWebDAVStorageTimeout(t *testing.T) {
+	timeout := webdavReadWriteTimeout
+	webdavReadWriteTimeout = 200 * time.Millisecond
+	defer func() { webdavReadWriteTimeout = timeout }()
+
+	root := t.TempDir()
+	handler := &webdav.Handler{
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "MOVE" && strings.Contains(r.URL.Path, "stall") {
+			<-release
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	ws := CreateWebDAVStorage(config.WebDAVConfig{URL: server.URL})
+
+	// 整个上传超过超时时间，但一直有数据写入，不会失败
+	content := strings.Repeat("backup content\n", 1000)
+	reader := &slowReader{data: []byte(content), delay: 40 * time.Millisecond}
+	start := time.Now()
+	if err := ws.PutStream("app_2024_01_01.zip", reader, 0, PutOptions{}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*webdavReadWriteTimeout {
+		t.Fatalf("upload took %v, should exceed the timeout", elapsed)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "app_2024_01_01.zip")); string(b) != content {
+		t.Fatalf("uploaded %d bytes, want %d", len(b), len(content))
+	}
+
+	// 服务端长时间没有响应时失败
+	err := ws.Put("stall.zip", filepath.Join(root, "app_2024_01_01.zip"), PutOptions{}, func(string) {})
+	close(release)
+	if err == nil {
+		t.Fatal("put should fail when server stalls")
+	}
+}
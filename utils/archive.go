@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩包格式
+const (
+	FormatZip    = "zip"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// ArchiveExtension 返回压缩格式对应的文件后缀，格式为空时默认 zip
+func ArchiveExtension(format string) (string, error) {
+	switch format {
+	case "", FormatZip:
+		return ".zip", nil
+	case FormatTarGz:
+		return ".tar.gz", nil
+	case FormatTarZst:
+		return ".tar.zst", nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %s, support zip, tar.gz, tar.zst", format)
+	}
+}
+
+// 压缩级别，store 只存储不压缩，适合已经压缩过的图片、视频等
+const (
+	LevelStore   = "store"
+	LevelFastest = "fastest"
+	LevelDefault = "default"
+	LevelBest    = "best"
+)
+
+// validateLevel 检查压缩级别，为空时使用默认级别
+func validateLevel(level string) error {
+	switch level {
+	case "", LevelStore, LevelFastest, LevelDefault, LevelBest:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression level %s, support store, fastest, default, best", level)
+	}
+}
+
+// flateLevel 返回 zip 和 gzip 使用的 deflate 压缩级别
+func flateLevel(level string) int {
+	switch level {
+	case LevelStore:
+		return flate.NoCompression
+	case LevelFastest:
+		return flate.BestSpeed
+	case LevelBest:
+		return flate.BestCompression
+	default:
+		return flate.DefaultCompression
+	}
+}
+
+// zstdLevel 返回 zstd 压缩级别，zstd 没有只存储的级别，store 使用最快级别，无法压缩的数据块会原样存储
+func zstdLevel(level string) zstd.EncoderLevel {
+	switch level {
+	case LevelStore, LevelFastest:
+		return zstd.SpeedFastest
+	case LevelBest:
+		return zstd.SpeedBestCompression
+	default:
+		return zstd.SpeedDefault
+	}
+}
+
+// archiveWriter 不同格式的压缩包写入器
+type archiveWriter interface {
+	// WriteHeader 写入条目，返回文件内容的写入器，不需要写入内容时返回 nil
+	WriteHeader(path, name string, info os.FileInfo) (io.Writer, error)
+	Close() error
+}
+
+// newArchiveWriter 按格式和压缩级别创建写入器，Parallel 大于 1 时 tar 格式并行压缩数据块，
+// zip 格式的并行压缩由 parallelZip 按文件进行
+func newArchiveWriter(w io.Writer, opts ArchiveOptions) (archiveWriter, error) {
+	switch opts.Format {
+	case "", FormatZip:
+		zw := zip.NewWriter(w)
+		if opts.Level == LevelStore {
+			return &zipArchiveWriter{zw: zw, method: zip.Store}, nil
+		}
+		level := flateLevel(opts.Level)
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+		return &zipArchiveWriter{zw: zw, method: zip.Deflate, level: level}, nil
+	case FormatTarGz:
+		if opts.Parallel > 1 {
+			return newTarArchiveWriter(newParallelGzipWriter(w, flateLevel(opts.Level), opts.Parallel)), nil
+		}
+		gw, err := gzip.NewWriterLevel(w, flateLevel(opts.Level))
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(gw), nil
+	case FormatTarZst:
+		zopts := []zstd.EOption{zstd.WithEncoderLevel(zstdLevel(opts.Level))}
+		if opts.Parallel > 0 {
+			zopts = append(zopts, zstd.WithEncoderConcurrency(opts.Parallel))
+		}
+		zw, err := zstd.NewWriter(w, zopts...)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(zw), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %s", opts.Format)
+	}
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+	// method 文件条目的压缩方式，level 为 deflate 压缩级别
+	method uint16
+	level  int
+}
+
+// header 生成条目头，目录名以 / 结尾，文件使用 method 压缩
+func (a *zipArchiveWriter) header(name string, info os.FileInfo) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Windows 路径分隔符转换为 ZIP 标准的 '/' 分隔符
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = a.method
+	}
+	return header, nil
+}
+
+func (a *zipArchiveWriter) WriteHeader(path, name string, info os.FileInfo) (io.Writer, error) {
+	header, err := a.header(name, info)
+	if err != nil {
+		return nil, err
+	}
+
+	// 符号链接按 Info-ZIP 的约定保存为内容是目标路径的条目
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := a.zw.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(writer, target)
+		return nil, err
+	}
+
+	writer, err := a.zw.CreateHeader(header)
+	if err != nil || info.IsDir() {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// tarArchiveWriter tar 格式保留属主、权限、符号链接和扩展属性，适合恢复 /etc 等目录
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+	closed     bool
+}
+
+func newTarArchiveWriter(compressor io.WriteCloser) *tarArchiveWriter {
+	return &tarArchiveWriter{tw: tar.NewWriter(compressor), compressor: compressor}
+}
+
+func (a *tarArchiveWriter) WriteHeader(path, name string, info os.FileInfo) (io.Writer, error) {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		link = target
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	if info.IsDir() && !strings.HasSuffix(header.Name, "/") {
+		header.Name += "/"
+	}
+	// PAX 格式支持长文件名和扩展属性
+	header.Format = tar.FormatPAX
+
+	// path 为空时是内存中生成的条目，没有扩展属性
+	if path != "" {
+		xattrs, err := readXattrs(path)
+		if err != nil {
+			return nil, fmt.Errorf("read xattrs failed: %w", err)
+		}
+		for k, v := range xattrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords["SCHILY.xattr."+k] = v
+		}
+	}
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	return a.tw, nil
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	if err := a.tw.Close(); err != nil {
+		a.compressor.Close()
+		return err
+	}
+	return a.compressor.Close()
+}
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestTarArchive(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "etc")
+	if err := os.MkdirAll(filepath.Join(source, "conf.d"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "conf.d", "app.conf"), []byte("listen 80"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("conf.d/app.conf", filepath.Join(source, "current.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	for format, decompress := range map[string]func(io.Reader) (io.Reader, error){
+		FormatTarGz: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		FormatTarZst: func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := ZipToWriter([]string{source}, &buf, ArchiveOptions{Format: format}, func(string, int64, int64, float64) {}, func(int64) {}); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := decompress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers := make(map[string]*tar.Header)
+			tr := tar.NewReader(r)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				headers[header.Name] = header
+			}
+
+			if h := headers["etc/conf.d/"]; h == nil || h.Typeflag != tar.TypeDir || h.FileInfo().Mode().Perm() != 0750 {
+				t.Fatalf("dir header %+v", h)
+			}
+			if h := headers["etc/conf.d/app.conf"]; h == nil || h.Size != int64(len("listen 80")) || h.FileInfo().Mode().Perm() != 0600 || h.Uid != os.Getuid() {
+				t.Fatalf("file header %+v", h)
+			}
+			if h := headers["etc/current.conf"]; h == nil || h.Typeflag != tar.TypeSymlink || h.Linkname != "conf.d/app.conf" {
+				t.Fatalf("symlink header %+v", h)
+			}
+		})
+	}
+}
+
+func TestGetFileName(t *testing.T) {
+	for format, ext := range map[string]string{"": ".zip", FormatZip: ".zip", FormatTarGz: ".tar.gz", FormatTarZst: ".tar.zst"} {
+		name := GetFileName("app", format)
+		if filepath.Ext(name) != filepath.Ext(ext) || name[len(name)-len(ext):] != ext {
+			t.Errorf("format %q got %s", format, name)
+		}
+
+		// 清理时仍能识别
+		if _, err := GetDefaultProcessor().Parse(name); err != nil {
+			t.Errorf("parse %s failed: %v", name, err)
+		}
+	}
+
+	if _, err := ArchiveExtension("rar"); err == nil {
+		t.Fatal("unsupported format should fail")
+	}
+}
+
+func TestArchiveExclude(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "app")
+	files := map[string]string{
+		"main.go":                   "package main",
+		"app.log":                   "log",
+		"keep.log":                  "log",
+		"node_modules/lib/index.js": "js",
+		"cache/data":                "cache",
+		"web/cache/page.html":       "page",
+		"web/.backupignore":         "*.tmp\n",
+		"web/build.tmp":             "tmp",
+		"build.tmp":                 "tmp",
+		"docs/readme.md":            "docs",
+		".backupignore":             "# deps\nnode_modules/\n*.log\n!keep.log\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := ArchiveOptions{Format: FormatTarGz, Exclude: []string{"/cache/", "docs/**"}}
+	var buf bytes.Buffer
+	info, err := ZipToWriter([]string{source}, &buf, opts, func(string, int64, int64, float64) {}, func(int64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// entries 读取归档中的条目，文件和目录分开返回
+	entries := func(buf *bytes.Buffer) (map[string]bool, map[string]bool) {
+		gr, err := gzip.NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		regs, dirs := map[string]bool{}, map[string]bool{}
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch hdr.Typeflag {
+			case tar.TypeReg:
+				regs[hdr.Name] = true
+			case tar.TypeDir:
+				dirs[strings.TrimSuffix(hdr.Name, "/")] = true
+			}
+		}
+		return regs, dirs
+	}
+	got, _ := entries(&buf)
+
+	for _, name := range []string{"app/main.go", "app/keep.log", "app/web/cache/page.html", "app/build.tmp"} {
+		if !got[name] {
+			t.Errorf("%s should be archived", name)
+		}
+	}
+	for _, name := range []string{"app/app.log", "app/node_modules/lib/index.js", "app/cache/data", "app/web/build.tmp", "app/docs/readme.md", "app/.backupignore", "app/web/.backupignore"} {
+		if got[name] {
+			t.Errorf("%s should be excluded", name)
+		}
+	}
+	// app.log、node_modules、cache、web/build.tmp、docs/readme.md、两个 .backupignore
+	if info.Excluded != 7 {
+		t.Errorf("excluded = %d, want 7", info.Excluded)
+	}
+
+	size, err := DirSize([]string{source}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want int64
+	for name := range got {
+		want += int64(len(files[name[len("app/"):]]))
+	}
+	if size != want {
+		t.Errorf("size = %d, want %d", size, want)
+	}
+
+	opts.Include = []string{"*.go", "web/"}
+	buf.Reset()
+	if _, err = ZipToWriter([]string{source}, &buf, opts, func(string, int64, int64, float64) {}, func(int64) {}); err != nil {
+		t.Fatal(err)
+	}
+	_, dirs := entries(&buf)
+	// 不包含保留文件的目录不写入空目录
+	for _, name := range []string{"app", "app/web", "app/web/cache"} {
+		if !dirs[name] {
+			t.Errorf("directory %s should be archived", name)
+		}
+	}
+	if len(dirs) != 3 {
+		t.Errorf("include dirs = %v", dirs)
+	}
+	size, err = DirSize([]string{source}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// main.go、web/cache/page.html
+	if want := int64(len("package main") + len("page")); size != want {
+		t.Errorf("include size = %d, want %d", size, want)
+	}
+
+	// include 直接匹配时保留 .backupignore
+	opts.Include = []string{"*.go", "web/", ".backupignore"}
+	buf.Reset()
+	if _, err = ZipToWriter([]string{source}, &buf, opts, func(string, int64, int64, float64) {}, func(int64) {}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = entries(&buf)
+	if !got["app/.backupignore"] || !got["app/web/.backupignore"] {
+		t.Errorf("explicitly included .backupignore should be archived: %v", got)
+	}
+}
+
+func TestArchiveMultipleSources(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"etc/app/app.conf":     "listen 80",
+		"data/app/db/data.bin": "data",
+		"dump.sql":             "select 1",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sources := []string{
+		filepath.Join(dir, "etc", "app"),
+		filepath.Join(dir, "data", "app"),
+		filepath.Join(dir, "dump.sql"),
+	}
+	var buf bytes.Buffer
+	if _, err := ZipToWriter(sources, &buf, ArchiveOptions{Format: FormatTarGz}, func(string, int64, int64, float64) {}, func(int64) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(tr)
+			got[hdr.Name] = string(data)
+		}
+	}
+
+	want := map[string]string{
+		"app/app.conf":      "listen 80",
+		"app_2/db/data.bin": "data",
+		"dump.sql":          "select 1",
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d files, want %d", len(got), len(want))
+	}
+
+	size, err := DirSize(sources, ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("listen 80")+len("data")+len("select 1")) {
+		t.Errorf("size = %d", size)
+	}
+
+	if _, err := ZipToWriter(append(sources, filepath.Join(dir, "missing")), io.Discard, ArchiveOptions{}, func(string, int64, int64, float64) {}, func(int64) {}); err == nil {
+		t.Error("missing source should fail")
+	}
+}
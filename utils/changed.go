@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// 读取过程中发生变化的文件的处理方式，warn 记录警告，retry 重新读取，仍然变化时记录警告，fail 使任务失败
+const (
+	PolicyWarn  = "warn"
+	PolicyRetry = "retry"
+)
+
+// changedRetries retry 策略下重新读取的次数，changedRetryDelay 为每次重新读取前的等待时间
+var (
+	changedRetries    = 3
+	changedRetryDelay = time.Second
+)
+
+// validateChangedPolicy 检查文件变化的处理方式，为空时为 warn
+func validateChangedPolicy(policy string) error {
+	switch policy {
+	case "", PolicyWarn, PolicyRetry, PolicyFail:
+		return nil
+	default:
+		return fmt.Errorf("unsupported changed files policy %s, support warn, retry, fail", policy)
+	}
+}
+
+// FileChanged 比较读取前后的文件信息，返回变化说明，未变化时返回空
+func FileChanged(before, after os.FileInfo) string {
+	switch {
+	case after.Size() != before.Size():
+		return fmt.Sprintf("读取过程中大小从 %d 变为 %d", before.Size(), after.Size())
+	case !after.ModTime().Equal(before.ModTime()):
+		return "读取过程中修改时间变化"
+	default:
+		return ""
+	}
+}
+
+// copyContent 复制文件开头 size 字节到 w，与条目头中的大小一致；文件变短时补零，由调用方按文件变化处理。
+// 每写入一段调用 progress，返回实际从文件读取的字节数
+func copyContent(w io.Writer, file *os.File, size int64, progress func(n int)) (int64, error) {
+	var read int64
+	buf := make([]byte, 32*1024)
+	for read < size {
+		nr, er := file.Read(buf[:min(int64(len(buf)), size-read)])
+		if nr > 0 {
+			nw, ew := w.Write(buf[:nr])
+			if nw > 0 {
+				progress(nw)
+			}
+			if ew != nil {
+				return read, fmt.Errorf("write file failed: %w", ew)
+			}
+			if nw != nr {
+				return read, fmt.Errorf("short write: wrote %d of %d bytes", nw, nr)
+			}
+			read += int64(nr)
+		}
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return read, fmt.Errorf("read file failed: %w", er)
+		}
+	}
+
+	if read < size {
+		if _, err := io.CopyN(w, zeroReader{}, size-read); err != nil {
+			return read, fmt.Errorf("write file failed: %w", err)
+		}
+	}
+	return read, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// RetryChanged 第 attempt 次读取（从 0 开始）后文件发生变化时判断是否重新读取，需要时等待 changedRetryDelay
+func RetryChanged(policy string, attempt int) bool {
+	if policy != PolicyRetry || attempt >= changedRetries {
+		return false
+	}
+	time.Sleep(changedRetryDelay)
+	return true
+}
+
+// readStable 读取文件内容直到读取前后文件没有变化，每次读取前调用 reset 丢弃上一次的数据，
+// 返回最后一次读取前、后的文件信息和变化说明，重试后仍然变化时变化说明不为空
+func readStable(file *os.File, policy string, tracker *ProgressTracker, reset func() io.Writer) (os.FileInfo, os.FileInfo, string, error) {
+	for attempt := 0; ; attempt++ {
+		before, err := file.Stat()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		var written int64
+		if _, err := copyContent(reset(), file, before.Size(), func(n int) {
+			written += int64(n)
+			tracker.IncProcessed(n)
+		}); err != nil {
+			return nil, nil, "", err
+		}
+		after, err := file.Stat()
+		if err != nil {
+			return nil, nil, "", err
+		}
+
+		changed := FileChanged(before, after)
+		if changed == "" || !RetryChanged(policy, attempt) {
+			return before, after, changed, nil
+		}
+
+		// 重新读取时撤销上一次的进度
+		tracker.IncProcessed(-int(written))
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, "", err
+		}
+	}
+}
+
+// manifestStat 返回清单中记录的文件信息：读取前后没有变化时为读取后的信息，与实际写入的内容一致；
+// 仍然变化时为读取前的信息，下次增量备份会重新读取该文件
+func manifestStat(before, after os.FileInfo, changed string) os.FileInfo {
+	if changed != "" {
+		return before
+	}
+	return after
+}
+
+// ChangedError fail 策略下文件在读取过程中发生变化的错误
+func ChangedError(path, reason string) error {
+	return fmt.Errorf("file %s changed during backup: %s", path, reason)
+}
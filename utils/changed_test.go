@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// changingWriter 收到 after 字节后执行一次 change，模拟压缩过程中源文件被修改
+type changingWriter struct {
+	bytes.Buffer
+	after  int
+	change func()
+}
+
+func (cw *changingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Buffer.Write(p)
+	if cw.change != nil && cw.Len() >= cw.after {
+		cw.change()
+		cw.change = nil
+	}
+	return n, err
+}
+
+func TestArchiveChangedFiles(t *testing.T) {
+	delay := changedRetryDelay
+	changedRetryDelay = 0
+	defer func() { changedRetryDelay = delay }()
+
+	content := make([]byte, 2*1024*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	setup := func(t *testing.T) (string, string) {
+		source := filepath.Join(t.TempDir(), "db")
+		if err := os.MkdirAll(source, 0750); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(source, "data.db")
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return source, file
+	}
+	noop := func(string, int64, int64, float64) {}
+	done := func(int64) {}
+
+	t.Run("warn", func(t *testing.T) {
+		source, file := setup(t)
+		// 文件变短时补零，tar 条目大小仍然与条目头一致
+		w := &changingWriter{after: 256 * 1024, change: func() {
+			if err := os.Truncate(file, 1024); err != nil {
+				t.Fatal(err)
+			}
+		}}
+		archive, err := ZipToWriter([]string{source}, w, ArchiveOptions{Format: FormatTarGz}, noop, done)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(archive.Changed) != 1 || archive.Changed[0].Path != file || !strings.Contains(archive.Changed[0].Reason, "大小") {
+			t.Fatalf("changed = %+v", archive.Changed)
+		}
+		contents, _ := readArchive(t, FormatTarGz, w.Bytes())
+		if len(contents["db/data.db"]) != len(content) {
+			t.Fatalf("entry size = %d, want %d", len(contents["db/data.db"]), len(content))
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		_, file := setup(t)
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// 第一次读取过程中文件被修改，重新读取后得到修改后的内容，进度不重复计算
+		modified := append(bytes.Clone(content), "appended"...)
+		tracker := NewProgressTracker(int64(len(modified)), noop, nil)
+		var out *changingWriter
+		before, _, changed, err := readStable(f, PolicyRetry, tracker, func() io.Writer {
+			first := out == nil
+			out = &changingWriter{}
+			if first {
+				out.after = 256 * 1024
+				out.change = func() {
+					if err := os.WriteFile(file, modified, 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			return out
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != "" || before.Size() != int64(len(modified)) || !bytes.Equal(out.Bytes(), modified) {
+			t.Fatalf("changed = %q, size = %d, read = %d", changed, before.Size(), out.Len())
+		}
+		if processed := *tracker.processed; processed != int64(len(modified)) {
+			t.Errorf("processed = %d, want %d", processed, len(modified))
+		}
+	})
+
+	t.Run("manifest stat", func(t *testing.T) {
+		_, file := setup(t)
+		// 遍历得到的信息已经过期，清单应记录实际读取的内容对应的信息
+		info, err := os.Lstat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified := append(bytes.Clone(content), "appended"...)
+		if err := os.WriteFile(file, modified, 0600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatal(err)
+		}
+		current, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, policy := range []string{PolicyWarn, PolicyRetry} {
+			archive, err := newArchiveWriter(io.Discard, ArchiveOptions{Format: FormatTarGz})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracker := NewProgressTracker(int64(len(modified)), noop, nil)
+			stat, _, err := writeArchiveEntry(archive, tracker, file, "db/data.db", info, sha256.New(), policy)
+			archive.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := info
+			if policy == PolicyRetry {
+				want = current
+			}
+			// warn 按遍历时的大小读取，内容与当前文件不一致，记录读取前的信息使下次重新读取
+			if stat.Size() != want.Size() || !stat.ModTime().Equal(want.ModTime()) {
+				t.Errorf("%s: stat = %d %v, want %d %v", policy, stat.Size(), stat.ModTime(), want.Size(), want.ModTime())
+			}
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		source, file := setup(t)
+		w := &changingWriter{after: 256 * 1024, change: func() {
+			future := time.Now().Add(time.Hour)
+			if err := os.Chtimes(file, future, future); err != nil {
+				t.Fatal(err)
+			}
+		}}
+		_, err := ZipToWriter([]string{source}, w, ArchiveOptions{Level: LevelStore, Changed: PolicyFail}, noop, done)
+		if err == nil || !strings.Contains(err.Error(), "changed during backup") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -62,6 +64,7 @@ const (
 	LogEntryTypeProgress LogEntryType = "progress" // 进度信息
 	LogEntryTypeInfo     LogEntryType = "info"     // 一般信息
 	LogEntryTypeError    LogEntryType = "error"    // 错误信息
+	LogEntryTypeWarning  LogEntryType = "warning"  // 警告信息，列在错误部分但不影响任务状态
 )
 
 // StepStatus 定义步骤的状态
@@ -71,8 +74,12 @@ const (
 	StepStatusStart   StepStatus = "start"
 	StepStatusSuccess StepStatus = "success"
 	StepStatusFailed  StepStatus = "failed"
+	StepStatusPartial StepStatus = "partial" // 部分失败，如多个上传目标中有失败
 )
 
+// ErrPartialFailed 步骤返回包装了该错误的 error 时记为部分失败
+var ErrPartialFailed = errors.New("partial failed")
+
 // LogEntry 结构化的日志条目
 type LogEntry struct {
 	Type      LogEntryType
@@ -96,7 +103,9 @@ type LogEntry struct {
 // === TaskLogger ===
 
 // TaskLogger 简化的任务日志记录器，只负责消息收集
+// Log* 方法可以并发调用，ExecuteStep 需要在同一个 goroutine 中调用
 type TaskLogger struct {
+	mu        sync.Mutex
 	taskID    string
 	startTime time.Time
 	message   Message
@@ -123,6 +132,9 @@ func (tl *TaskLogger) GetMessageAndClean() string {
 	duration := time.Since(tl.startTime)
 	tl.LogInfo("【%s】任务总耗时: %v", tl.taskID, duration)
 
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
 	result := tl.message.String("\n")
 	tl.message.Clean()
 	return result
@@ -138,11 +150,9 @@ func (tl *TaskLogger) LogInfo(format string, args ...interface{}) {
 		Timestamp: time.Now(),
 		Message:   message,
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容
-	tl.message.Add(message)
-	log.Println(message)
+	tl.addEntry(entry, message)
 }
 
 // LogError 记录错误信息
@@ -154,12 +164,22 @@ func (tl *TaskLogger) LogError(err error, format string, args ...interface{}) {
 		Message:   message,
 		Error:     err,
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容
 	fullMessage := fmt.Sprintf("%s: %v", message, err)
-	tl.message.Add(fullMessage)
-	log.Println(fullMessage)
+	tl.addEntry(entry, fullMessage)
+}
+
+// LogWarning 记录警告信息，如备份过程中发生变化的文件
+func (tl *TaskLogger) LogWarning(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	entry := LogEntry{
+		Type:      LogEntryTypeWarning,
+		Timestamp: time.Now(),
+		Message:   message,
+	}
+
+	tl.addEntry(entry, "警告: "+message)
 }
 
 // LogProgress 记录进度信息
@@ -173,17 +193,28 @@ func (tl *TaskLogger) LogProgress(filePath string, processed, total int64, perce
 		Percentage: percentage,
 		Message:    fmt.Sprintf("进度: %s (%.1f%%)", filePath, percentage),
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容
 	message := fmt.Sprintf("进度: %s - %s / %s (%.1f%%)",
 		filePath, FormatBytes(processed), FormatBytes(total), percentage)
+	tl.addEntry(entry, message)
+}
+
+// addEntry 记录日志条目和兼容消息
+func (tl *TaskLogger) addEntry(entry LogEntry, message string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.entries = append(tl.entries, entry)
 	tl.message.Add(message)
 	log.Println(message)
 }
 
 // GetEntries 返回所有日志条目
 func (tl *TaskLogger) GetEntries() []LogEntry {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
 	return tl.entries
 }
 
@@ -202,6 +233,9 @@ func (tl *TaskLogger) ExecuteStep(stepName string, fn func() error) (err error)
 			// 捕获 panic 并转换为 error
 			err = fmt.Errorf("panic: %v", r)
 			tl.stepFailed(stepName, err)
+		} else if errors.Is(err, ErrPartialFailed) {
+			// 部分失败
+			tl.stepPartial(stepName, err)
 		} else if err != nil {
 			// 函数返回了错误
 			tl.stepFailed(stepName, err)
@@ -224,12 +258,10 @@ func (tl *TaskLogger) stepStart(stepName string) {
 		StepStatus: StepStatusStart,
 		Message:    fmt.Sprintf("开始: %s", stepName),
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容，同时记录到旧的消息系统
 	message := fmt.Sprintf("【%s】%s 开始", tl.taskID, stepName)
-	tl.message.Add(message)
-	log.Println(message)
+	tl.addEntry(entry, message)
 }
 
 // stepSuccess 记录步骤成功并出栈
@@ -246,12 +278,10 @@ func (tl *TaskLogger) stepSuccess(stepName string) {
 		StepStatus: StepStatusSuccess,
 		Message:    fmt.Sprintf("完成: %s", stepName),
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容
 	message := fmt.Sprintf("【%s】%s 完成", tl.taskID, stepName)
-	tl.message.Add(message)
-	log.Println(message)
+	tl.addEntry(entry, message)
 }
 
 // stepFailed 记录步骤失败并出栈
@@ -269,10 +299,29 @@ func (tl *TaskLogger) stepFailed(stepName string, err error) {
 		Message:    fmt.Sprintf("失败: %s", stepName),
 		Error:      err,
 	}
-	tl.entries = append(tl.entries, entry)
 
 	// 保持向后兼容
 	message := fmt.Sprintf("【%s】%s 失败: %v", tl.taskID, stepName, err)
-	tl.message.Add(message)
-	log.Println(message)
+	tl.addEntry(entry, message)
+}
+
+// stepPartial 记录步骤部分失败并出栈
+func (tl *TaskLogger) stepPartial(stepName string, err error) {
+	// 出栈
+	if len(tl.stepStack) > 0 {
+		tl.stepStack = tl.stepStack[:len(tl.stepStack)-1]
+	}
+
+	entry := LogEntry{
+		Type:       LogEntryTypeStep,
+		Timestamp:  time.Now(),
+		StepName:   stepName,
+		StepStatus: StepStatusPartial,
+		Message:    fmt.Sprintf("部分失败: %s", stepName),
+		Error:      err,
+	}
+
+	// 保持向后兼容
+	message := fmt.Sprintf("【%s】%s 部分失败: %v", tl.taskID, stepName, err)
+	tl.addEntry(entry, message)
 }
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// EncryptedSuffix 加密后的压缩包后缀
+const EncryptedSuffix = ".age"
+
+// EncryptFunc 包装 w，写入的数据加密后再写入 w，关闭时写入结尾
+type EncryptFunc func(w io.Writer) (io.WriteCloser, error)
+
+// NewEncryptor 返回 age 加密函数和加密方式，recipients 为 age 公钥，与 passphrase 二选一
+func NewEncryptor(recipients []string, passphrase string) (EncryptFunc, string, error) {
+	if len(recipients) > 0 && passphrase != "" {
+		return nil, "", errors.New("encrypt recipients and passphrase can not be used together")
+	}
+
+	var rs []age.Recipient
+	scheme := "age-x25519"
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("parse recipient %s failed: %w", r, err)
+		}
+		rs = append(rs, recipient)
+	}
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, "", err
+		}
+		rs = append(rs, recipient)
+		scheme = "age-scrypt"
+	}
+	if len(rs) <= 0 {
+		return nil, "", errors.New("encrypt recipients or passphrase must be set")
+	}
+
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(w, rs...)
+	}, scheme, nil
+}
+
+// NewDecryptReader 使用 age 私钥文件或口令解密
+func NewDecryptReader(r io.Reader, identityFile, passphrase string) (io.Reader, error) {
+	var ids []age.Identity
+	if identityFile != "" {
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("open identity file failed: %w", err)
+		}
+		defer f.Close()
+
+		parsed, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse identity file failed: %w", err)
+		}
+		ids = append(ids, parsed...)
+	}
+	if passphrase != "" {
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) <= 0 {
+		return nil, errors.New("identity_file or passphrase must be set to decrypt")
+	}
+
+	return age.Decrypt(r, ids...)
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryptedArchive(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "dump.sql"), []byte("secret customer data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		recipients   []string
+		passphrase   string
+		identityFile string
+	}{
+		"x25519":     {recipients: []string{identity.Recipient().String()}, identityFile: identityFile},
+		"passphrase": {passphrase: "correct horse battery staple"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			encrypt, _, err := NewEncryptor(tc.recipients, tc.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			info, err := ZipToWriter([]string{source}, &buf, ArchiveOptions{Encrypt: encrypt}, func(string, int64, int64, float64) {}, func(int64) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 校验值按上传的密文计算
+			sum := sha256.Sum256(buf.Bytes())
+			if info.Size != int64(buf.Len()) || info.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Fatalf("checksum should match encrypted bytes, got %+v", info)
+			}
+			if bytes.Contains(buf.Bytes(), []byte("secret customer data")) || bytes.Contains(buf.Bytes(), []byte("dump.sql")) {
+				t.Fatal("archive is not encrypted")
+			}
+
+			reader, err := NewDecryptReader(&buf, tc.identityFile, tc.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(plain), int64(len(plain)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(zr.File) != 2 || zr.File[1].Name != "data/dump.sql" {
+				t.Fatalf("unexpected zip entries %v", zr.File)
+			}
+		})
+	}
+
+	if _, _, err := NewEncryptor([]string{identity.Recipient().String()}, "passphrase"); err == nil {
+		t.Fatal("recipients and passphrase should not be used together")
+	}
+}
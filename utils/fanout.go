@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrAllWritersFailed 所有目标都已写入失败
+var ErrAllWritersFailed = errors.New("all writers failed")
+
+// FanOutWriter 把数据依次写入多个目标，单个目标失败后不再写入，其余目标继续；
+// 写入是同步的，整体速度取决于最慢的目标
+type FanOutWriter struct {
+	writers []io.Writer
+	errs    []error
+}
+
+// NewFanOutWriter 创建 FanOutWriter，nil 的目标视为已失败
+func NewFanOutWriter(writers ...io.Writer) *FanOutWriter {
+	fw := &FanOutWriter{
+		writers: writers,
+		errs:    make([]error, len(writers)),
+	}
+	for i, w := range writers {
+		if w == nil {
+			fw.errs[i] = io.ErrClosedPipe
+		}
+	}
+	return fw
+}
+
+func (fw *FanOutWriter) Write(p []byte) (int, error) {
+	alive := 0
+	for i, w := range fw.writers {
+		if fw.errs[i] != nil {
+			continue
+		}
+
+		n, err := w.Write(p)
+		if err == nil && n != len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			fw.errs[i] = err
+			continue
+		}
+		alive++
+	}
+
+	if alive <= 0 {
+		return 0, ErrAllWritersFailed
+	}
+	return len(p), nil
+}
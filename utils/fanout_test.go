@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestFanOutWriter(t *testing.T) {
+	var a, b bytes.Buffer
+	fw := NewFanOutWriter(&a, failWriter{}, nil, &b)
+
+	for _, s := range []string{"hello ", "world"} {
+		if _, err := io.WriteString(fw, s); err != nil {
+			t.Fatalf("write should succeed while some writers alive: %v", err)
+		}
+	}
+	if a.String() != "hello world" || b.String() != "hello world" {
+		t.Fatalf("got %q, %q", a.String(), b.String())
+	}
+
+	fw = NewFanOutWriter(failWriter{}, nil)
+	if _, err := fw.Write([]byte("x")); !errors.Is(err, ErrAllWritersFailed) {
+		t.Fatalf("got %v, want ErrAllWritersFailed", err)
+	}
+}
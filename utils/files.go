@@ -1,13 +1,18 @@
 package utils
 
 import (
-	"archive/zip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc64"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -20,7 +25,7 @@ type ProgressTracker struct {
 	processed    *int64
 	total        int64
 	callback     ProgressCallback
-	currentFile  string
+	currentFile  atomic.Value
 	done         chan bool
 	doneCallback ProgressDoneCallback
 }
@@ -46,8 +51,9 @@ func (pt *ProgressTracker) Start() {
 		for {
 			select {
 			case <-ticker.C:
+				current, _ := pt.currentFile.Load().(string)
 				pt.callback(
-					pt.currentFile,
+					current,
 					atomic.LoadInt64(pt.processed),
 					pt.total,
 					float64(atomic.LoadInt64(pt.processed))/float64(pt.total)*100,
@@ -68,54 +74,200 @@ func (pt *ProgressTracker) Stop() {
 	}
 }
 
+// UpdateCurrentFile 记录正在处理的文件，并行压缩时为最近开始的文件
 func (pt *ProgressTracker) UpdateCurrentFile(path string) {
-	pt.currentFile = path
+	pt.currentFile.Store(path)
 }
 
 func (pt *ProgressTracker) IncProcessed(size int) {
 	atomic.AddInt64(pt.processed, int64(size))
 }
 
-func ZipPath(source string, target string, callback ProgressCallback, doneCallback ProgressDoneCallback) (string, error) {
-	source = filepath.Clean(source)
-	target = filepath.Clean(target)
-	log.Printf("zip path: %s, target: %s", source, target)
+// ArchiveInfo 压缩包信息，校验值在写入压缩包时同步计算
+type ArchiveInfo struct {
+	Path   string
+	Size   int64
+	MD5    string // hex
+	SHA256 string // hex
+	CRC64  uint64 // ECMA 多项式，与 OSS 的 x-oss-hash-crc64ecma 一致
+	// Excluded 按排除规则跳过的文件和目录数，跳过的目录只计一次
+	Excluded int
+	// Skipped 特殊文件、无法读取等按策略跳过的文件
+	Skipped []SkippedFile
+	// Manifest 本次备份的文件清单，开启 Manifest 或增量备份时不为空
+	Manifest *Manifest
+	// Deleted 增量备份中相对全量备份已删除的文件数
+	Deleted int
+	// Changed 读取过程中发生变化的文件，内容可能不一致
+	Changed []SkippedFile
+	// Volumes 分卷压缩时各分卷的路径、大小和校验值
+	Volumes []*ArchiveInfo
+}
+
+// checksumWriter 写入时同步统计大小并计算校验值
+type checksumWriter struct {
+	w      io.Writer
+	size   int64
+	md5    hash.Hash
+	sha256 hash.Hash
+	crc64  hash.Hash64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{
+		w:      w,
+		md5:    md5.New(),
+		sha256: sha256.New(),
+		crc64:  crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}
+}
 
-	info, err := os.Stat(source)
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		cw.size += int64(n)
+		cw.md5.Write(p[:n])
+		cw.sha256.Write(p[:n])
+		cw.crc64.Write(p[:n])
+	}
+	return n, err
+}
+
+// ChecksumFile 计算文件的大小和校验值
+func ChecksumFile(path string) (*ArchiveInfo, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return "", fmt.Errorf("stat source path failed: %w", err)
+		return nil, err
 	}
+	defer f.Close()
 
-	if !info.IsDir() {
-		return "", errors.New("source path is not a directory")
+	cw := newChecksumWriter(io.Discard)
+	if _, err := io.Copy(cw, f); err != nil {
+		return nil, err
 	}
+	info := cw.archiveInfo()
+	info.Path = path
+	return info, nil
+}
+
+func (cw *checksumWriter) archiveInfo() *ArchiveInfo {
+	return &ArchiveInfo{
+		Size:   cw.size,
+		MD5:    hex.EncodeToString(cw.md5.Sum(nil)),
+		SHA256: hex.EncodeToString(cw.sha256.Sum(nil)),
+		CRC64:  cw.crc64.Sum64(),
+	}
+}
+
+// ArchiveOptions 压缩选项
+type ArchiveOptions struct {
+	// Format 压缩格式 zip、tar.gz、tar.zst，默认 zip
+	Format string
+	// Encrypt 不为空时对压缩包加密，校验值按加密后的数据计算
+	Encrypt EncryptFunc
+	// Exclude 排除的文件，gitignore 语法，与源目录中的 .backupignore 一起生效
+	Exclude []string
+	// Include 不为空时只压缩匹配的文件
+	Include []string
+	// Symlinks 符号链接保存为链接 store 或保存指向的内容 follow，默认 store
+	Symlinks string
+	// SpecialFiles socket、FIFO、设备文件跳过 skip 或失败 fail，默认 skip
+	SpecialFiles string
+	// Unreadable 无法读取的文件跳过 skip 或失败 fail，默认 fail
+	Unreadable string
+	// Manifest 记录文件清单，结果在 ArchiveInfo.Manifest 中
+	Manifest bool
+	// Base 不为空时为增量备份，只压缩相对 Base 新增或变化的文件，并写入已删除文件列表
+	Base *Manifest
+	// Level 压缩级别 store、fastest、default、best，默认 default
+	Level string
+	// Changed 文件在读取过程中发生变化时记录警告 warn、重新读取 retry 或失败 fail，默认 warn；
+	// retry 先把文件读到内存或临时文件，确认没有变化后再写入压缩包
+	Changed string
+	// Parallel 大于 1 时并行压缩，zip 同时压缩多个文件，tar.gz、tar.zst 并行压缩数据块
+	Parallel int
+}
+
+// ZipPath 压缩目录或文件到 target 文件
+func ZipPath(sources []string, target string, opts ArchiveOptions, callback ProgressCallback, doneCallback ProgressDoneCallback) (*ArchiveInfo, error) {
+	target = filepath.Clean(target)
+	log.Printf("zip path: %s, target: %s", strings.Join(sources, ", "), target)
 
 	// 验证目标路径
 	targetDir := filepath.Dir(target)
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return "", fmt.Errorf("create target directory failed: %w", err)
+		return nil, fmt.Errorf("create target directory failed: %w", err)
 	}
 
-	baseDir := filepath.Base(source)
 	zipfile, err := os.Create(target)
 	if err != nil {
-		return "", fmt.Errorf("create zip file failed: %w", err)
+		return nil, fmt.Errorf("create zip file failed: %w", err)
 	}
 	defer zipfile.Close()
 
-	// 计算总大小
+	archiveInfo, err := ZipToWriter(sources, zipfile, opts, callback, doneCallback)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveInfo.Path = target
+	return archiveInfo, nil
+}
+
+// DirSize 计算目录和文件中按排除规则保留的文件总大小，增量备份时只计算变化的文件
+func DirSize(sources []string, opts ArchiveOptions) (int64, error) {
 	var totalSize int64
-	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
+	_, _, err := WalkSources(sources, opts, func(_, name string, info os.FileInfo) error {
+		if !info.IsDir() && opts.Base.changed(name, info) {
 			totalSize += info.Size()
 		}
 		return nil
 	})
+	return totalSize, err
+}
+
+// archiveSource 压缩的源路径及其在压缩包中的顶层名称
+type archiveSource struct {
+	path string
+	name string
+}
+
+// resolveSources 检查源路径，顶层名称取路径最后一级，重名时依次加 _2、_3 后缀
+func resolveSources(sources []string) ([]archiveSource, error) {
+	if len(sources) <= 0 {
+		return nil, errors.New("source path can not be empty")
+	}
+
+	used := make(map[string]bool)
+	result := make([]archiveSource, 0, len(sources))
+	for _, source := range sources {
+		source = filepath.Clean(source)
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("stat source path failed: %w", err)
+		}
+
+		base := filepath.Base(source)
+		name := base
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[name] = true
+		result = append(result, archiveSource{path: source, name: name})
+	}
+	return result, nil
+}
+
+// ZipToWriter 压缩目录和文件并写入 w，每个源路径在压缩包中是独立的顶层目录或文件，
+// 用于不落盘的流式上传，返回的 ArchiveInfo 不含 Path
+func ZipToWriter(sources []string, w io.Writer, opts ArchiveOptions, callback ProgressCallback, doneCallback ProgressDoneCallback) (*ArchiveInfo, error) {
+	if _, err := resolveSources(sources); err != nil {
+		return nil, err
+	}
+
+	// 计算总大小
+	totalSize, err := DirSize(sources, opts)
 	if err != nil {
-		return "", fmt.Errorf("calculate total size failed: %w", err)
+		return nil, fmt.Errorf("calculate total size failed: %w", err)
 	}
 
 	// 创建进度追踪器
@@ -123,79 +275,229 @@ func ZipPath(source string, target string, callback ProgressCallback, doneCallba
 	tracker.Start()
 	defer tracker.Stop()
 
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+	checksum := newChecksumWriter(w)
+	var out io.Writer = checksum
+	var encryptor io.WriteCloser
+	if opts.Encrypt != nil {
+		encryptor, err = opts.Encrypt(checksum)
 		if err != nil {
-			return fmt.Errorf("walk failed: %w", err)
+			return nil, fmt.Errorf("create encryptor failed: %w", err)
 		}
+		out = encryptor
+	}
 
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return fmt.Errorf("create file header failed: %w", err)
+	archive, err := newArchiveWriter(out, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var files map[string]ManifestEntry
+	if opts.Manifest || opts.Base != nil {
+		files = make(map[string]ManifestEntry)
+	}
+
+	// zip 并行压缩时文件的 SHA256 在写入完成后才能取得
+	var pz *parallelZip
+	var pending []*zipJob
+	if za, ok := archive.(*zipArchiveWriter); ok && opts.Parallel > 1 {
+		pz = newParallelZip(za, tracker, opts.Parallel, opts.Changed)
+	}
+	var changed []SkippedFile
+	// write 写入一个条目，返回清单中记录的文件信息；并行压缩时读取完成后再由 pending 更新
+	write := func(path, name string, info os.FileInfo, h hash.Hash) (os.FileInfo, error) {
+		if pz == nil {
+			stat, reason, err := writeArchiveEntry(archive, tracker, path, name, info, h, opts.Changed)
+			if reason != "" {
+				changed = append(changed, SkippedFile{Path: path, Reason: reason})
+			}
+			return stat, err
 		}
+		job, err := pz.add(path, name, info, h != nil)
+		if err == nil && h != nil && job.file != nil {
+			pending = append(pending, job)
+		}
+		return info, err
+	}
 
-		relPath, err := filepath.Rel(source, path)
-		if err != nil {
-			return fmt.Errorf("rel path failed: %w", err)
+	excluded, skipped, err := WalkSources(sources, opts, func(path, name string, info os.FileInfo) error {
+		if info.IsDir() || files == nil {
+			_, err := write(path, name, info, nil)
+			return err
 		}
 
-		// Windows 路径分隔符转换为 ZIP 标准的 '/' 分隔符
-		header.Name = filepath.ToSlash(filepath.Join(baseDir, relPath))
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
+		// 未变化的文件沿用全量备份的记录
+		if !opts.Base.changed(name, info) {
+			files[name] = opts.Base.Files[name]
+			return nil
 		}
 
-		writer, err := archive.CreateHeader(header)
+		h := sha256.New()
+		stat, err := write(path, name, info, h)
 		if err != nil {
-			return fmt.Errorf("create header failed: %w", err)
+			return err
+		}
+		entry := ManifestEntry{Size: stat.Size(), ModTime: stat.ModTime()}
+		if info.Mode().IsRegular() && pz == nil {
+			entry.Hash = hex.EncodeToString(h.Sum(nil))
 		}
+		files[name] = entry
+		return nil
+	})
+	if pz != nil {
+		if closeErr := pz.close(); err == nil {
+			err = closeErr
+		}
+		changed = pz.changed
+		for _, job := range pending {
+			entry := files[job.name]
+			entry.Hash = job.sum
+			if job.stat != nil {
+				entry.Size, entry.ModTime = job.stat.Size(), job.stat.ModTime()
+			}
+			files[job.name] = entry
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zip failed: %w", err)
+	}
 
-		if info.IsDir() {
-			return nil
+	var deleted []string
+	if opts.Base != nil {
+		deleted = opts.Base.deleted(files)
+		if err := writeDeletedList(archive, deleted); err != nil {
+			return nil, fmt.Errorf("write deleted list failed: %w", err)
 		}
+	}
+
+	// 写入结尾后校验值才完整
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("close archive failed: %w", err)
+	}
+	if encryptor != nil {
+		if err := encryptor.Close(); err != nil {
+			return nil, fmt.Errorf("close encryptor failed: %w", err)
+		}
+	}
 
-		file, err := os.Open(path)
+	archiveInfo := checksum.archiveInfo()
+	archiveInfo.Excluded = excluded
+	archiveInfo.Skipped = skipped
+	archiveInfo.Changed = changed
+	archiveInfo.Deleted = len(deleted)
+	if files != nil {
+		archiveInfo.Manifest = &Manifest{Files: files}
+	}
+	return archiveInfo, nil
+}
+
+// writeArchiveEntry 写入一个文件或目录，文件内容按读取进度更新 tracker，hash 不为空时同时写入文件内容；
+// 文件在读取过程中发生变化时按 policy 处理，返回清单中记录的文件信息和变化说明
+func writeArchiveEntry(archive archiveWriter, tracker *ProgressTracker, path, name string, info os.FileInfo, hash hash.Hash, policy string) (os.FileInfo, string, error) {
+	// 先打开文件，无法读取时还未写入条目，可以跳过
+	var file *os.File
+	if info.Mode().IsRegular() {
+		f, err := os.Open(path)
 		if err != nil {
-			return fmt.Errorf("open file failed: %w", err)
+			return nil, "", &unreadableError{err: err}
 		}
-		defer file.Close()
+		defer f.Close()
+		file = f
+	}
 
-		tracker.UpdateCurrentFile(path)
+	if file == nil {
+		if _, err := archive.WriteHeader(path, name, info); err != nil {
+			return nil, "", fmt.Errorf("create header failed: %w", err)
+		}
+		return info, "", nil
+	}
+	tracker.UpdateCurrentFile(path)
 
-		buf := make([]byte, 32*1024) // buffer
-		for {
-			nr, er := file.Read(buf)
-			if nr > 0 {
-				nw, ew := writer.Write(buf[:nr])
-				if nw > 0 {
-					tracker.IncProcessed(nw)
-				}
-				if ew != nil {
-					return fmt.Errorf("write file failed: %w", ew)
-				}
-				if nw != nr {
-					return fmt.Errorf("short write: wrote %d of %d bytes", nw, nr)
-				}
-			}
-			if er == io.EOF {
-				break
-			}
-			if er != nil {
-				return fmt.Errorf("read file failed: %w", er)
+	// retry 需要在写入条目前确认文件没有变化，先把内容读到临时缓冲区
+	if policy == PolicyRetry {
+		var spool spillBuffer
+		defer spool.release()
+		before, after, changed, err := readStable(file, policy, tracker, func() io.Writer {
+			spool.release()
+			spool.size = 0
+			if hash != nil {
+				hash.Reset()
+				return io.MultiWriter(&spool, hash)
 			}
+			return &spool
+		})
+		if err != nil {
+			return nil, "", err
 		}
 
-		return nil
-	})
+		writer, err := archive.WriteHeader(path, name, before)
+		if err != nil {
+			return nil, "", fmt.Errorf("create header failed: %w", err)
+		}
+		r, err := spool.reader()
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := io.Copy(writer, r); err != nil {
+			return nil, "", fmt.Errorf("write file failed: %w", err)
+		}
+		return manifestStat(before, after, changed), changed, nil
+	}
 
+	writer, err := archive.WriteHeader(path, name, info)
 	if err != nil {
-		e := fmt.Errorf("zip failed: %w", err)
-		return "", e
+		return nil, "", fmt.Errorf("create header failed: %w", err)
+	}
+	if hash != nil {
+		writer = io.MultiWriter(writer, hash)
+	}
+	if _, err := copyContent(writer, file, info.Size(), tracker.IncProcessed); err != nil {
+		return nil, "", err
 	}
 
-	return target, nil
+	after, err := file.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	changed := FileChanged(info, after)
+	if changed != "" && policy == PolicyFail {
+		return nil, "", ChangedError(path, changed)
+	}
+	return manifestStat(info, after, changed), changed, nil
 }
+
+// writeDeletedList 在压缩包根目录写入已删除文件列表
+func writeDeletedList(archive archiveWriter, deleted []string) error {
+	var content []byte
+	for _, name := range deleted {
+		content = append(content, name...)
+		content = append(content, '\n')
+	}
+
+	writer, err := archive.WriteHeader("", DeletedListName, memFileInfo{
+		name:    DeletedListName,
+		size:    int64(len(content)),
+		mode:    0644,
+		modTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+// memFileInfo 内存中生成的压缩包条目
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
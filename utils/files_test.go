@@ -1,22 +1,80 @@
 package utils
 
 import (
-	"log"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func Test_zipPath(t *testing.T) {
-	target := "D:/test.zip"
-	path, err := ZipPath(`F:\zip_demo`, target, func(filePath string, processed, total int64, percentage float64) {
-		log.Printf("zip %s: %d/%d (%.2f%%)", filePath, processed, total, percentage)
-	}, func(total int64) {
-		log.Printf("zip done, total: %d", total)
+	source := filepath.Join(t.TempDir(), "zip_demo")
+	files := map[string]string{
+		"a.txt":     "hello",
+		"sub/b.txt": strings.Repeat("backup content\n", 1000),
+	}
+	for name, content := range files {
+		p := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := filepath.Join(t.TempDir(), "test.zip")
+	var total int64
+	archive, err := ZipPath([]string{source}, target, ArchiveOptions{}, func(string, int64, int64, float64) {}, func(n int64) {
+		total = n
 	})
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	log.Printf("path %s", path)
-	os.Remove(target)
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if archive.Path != target || archive.Size != int64(len(data)) || archive.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("archive = %+v, size = %d", archive, len(data))
+	}
+	if total <= 0 {
+		t.Errorf("total = %d", total)
+	}
+
+	zr, err := zip.OpenReader(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(b)
+	}
+	for name, content := range files {
+		if got["zip_demo/"+name] != content {
+			t.Errorf("content of %s mismatch", name)
+		}
+	}
+	if len(got) != len(files) {
+		t.Errorf("entries = %v", got)
+	}
 }
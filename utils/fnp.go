@@ -89,6 +89,11 @@ func IsNeedDeleteFile(prefix, name string) bool {
 	return fileDate.Before(beforeDate)
 }
 
-func GetFileName(prefix string) string {
-	return GetDefaultProcessor().Generate(prefix, time.Now()) + ".zip"
+// GetFileName 返回压缩包文件名，后缀与压缩格式一致
+func GetFileName(prefix, format string) string {
+	ext, err := ArchiveExtension(format)
+	if err != nil {
+		ext = ".zip"
+	}
+	return GetDefaultProcessor().Generate(prefix, time.Now()) + ext
 }
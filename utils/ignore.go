@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName 源目录中的忽略规则文件，语法与 .gitignore 相同，可以放在任意子目录
+const IgnoreFileName = ".backupignore"
+
+// ignoreRule 一条 gitignore 语法的规则
+type ignoreRule struct {
+	// base 规则所在目录，相对源目录，根目录为空
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	// \# \! 转义开头的特殊字符
+	line = strings.TrimPrefix(line, `\`)
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// 不含 / 的规则匹配任意层级的同名文件，含 / 的规则相对所在目录
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	rule.segments = strings.Split(line, "/")
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+
+	return rule, true
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments 逐级匹配路径，** 匹配任意层目录
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(segments) > 0
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// pathFilter 按 .backupignore 和任务配置的 exclude、include 过滤源目录中的文件
+type pathFilter struct {
+	root    string
+	exclude []ignoreRule
+	include []ignoreRule
+	// ignoreFiles 已读取的各目录 .backupignore 规则
+	ignoreFiles map[string][]ignoreRule
+}
+
+func newPathFilter(root string, exclude, include []string) *pathFilter {
+	f := &pathFilter{
+		root:        root,
+		ignoreFiles: make(map[string][]ignoreRule),
+	}
+	for _, pattern := range exclude {
+		if rule, ok := parseIgnoreRule("", pattern); ok {
+			f.exclude = append(f.exclude, rule)
+		}
+	}
+	for _, pattern := range include {
+		if rule, ok := parseIgnoreRule("", pattern); ok {
+			f.include = append(f.include, rule)
+		}
+	}
+	return f
+}
+
+// skip 判断相对路径 rel 是否跳过；.backupignore 中深层目录的规则优先，任务配置的 exclude 最后生效；
+// .backupignore 本身默认跳过，被 ! 规则或 include 规则直接匹配时保留；
+// 配置了 include 时只保留匹配的文件，目录仍然会进入
+func (f *pathFilter) skip(rel string, isDir bool) (bool, error) {
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == "" {
+		return false, nil
+	}
+
+	var rules []ignoreRule
+	dirs := []string{""}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+	for _, dir := range dirs {
+		fileRules, err := f.loadIgnoreFile(dir)
+		if err != nil {
+			return false, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	rules = append(rules, f.exclude...)
+
+	excluded, matched := false, false
+	for _, rule := range rules {
+		if rule.match(rel, isDir) {
+			excluded, matched = !rule.negate, true
+		}
+	}
+	if excluded {
+		return true, nil
+	}
+
+	if !isDir && path.Base(rel) == IgnoreFileName && !matched {
+		for _, rule := range f.include {
+			if rule.match(rel, false) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	if isDir {
+		return false, nil
+	}
+	return !f.included(rel, false), nil
+}
+
+// included 没有配置 include，或路径本身、上级目录匹配 include 时返回 true
+func (f *pathFilter) included(rel string, isDir bool) bool {
+	if len(f.include) <= 0 {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for _, rule := range f.include {
+		if rule.match(rel, isDir) {
+			return true
+		}
+		// 匹配上级目录时目录下的文件都保留
+		for i := 1; i < len(parts); i++ {
+			if rule.match(strings.Join(parts[:i], "/"), true) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *pathFilter) loadIgnoreFile(dir string) ([]ignoreRule, error) {
+	if rules, ok := f.ignoreFiles[dir]; ok {
+		return rules, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.root, filepath.FromSlash(dir), IgnoreFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		if rule, ok := parseIgnoreRule(dir, line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	f.ignoreFiles[dir] = rules
+	return rules, nil
+}
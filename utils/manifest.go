@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+)
+
+// 增量备份的压缩包类型，位于文件名日期之后
+const (
+	BackupFull        = "full"
+	BackupIncremental = "inc"
+)
+
+// DeletedListName 增量压缩包中记录相对全量备份已删除文件的条目，每行一个路径
+const DeletedListName = ".backup-deleted"
+
+var backupKindRegexp = regexp.MustCompile(`_\d{4}_\d{2}_\d{2}_(full|inc)\.`)
+
+// ManifestEntry 清单中一个文件的信息
+type ManifestEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Hash 文件内容 SHA256，符号链接为空
+	Hash string `json:"hash,omitempty"`
+}
+
+// Manifest 最近一次全量备份的文件清单，增量备份只打包与清单相比新增或变化的文件
+type Manifest struct {
+	// Full 全量备份的对象 key
+	Full    string    `json:"full"`
+	Time    time.Time `json:"time"`
+	Sources []string  `json:"sources"`
+	Format  string    `json:"format"`
+	// Files key 为压缩包中的路径
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+// LoadManifest 读取清单文件，文件不存在时返回 nil
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest %s failed: %w", path, err)
+	}
+	return &m, nil
+}
+
+// Save 写入清单文件，先写临时文件再替换，避免中断时损坏原清单
+func (m *Manifest) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Compatible 判断备份路径和格式是否与清单一致，不一致时需要重新全量备份
+func (m *Manifest) Compatible(sources []string, format string) bool {
+	return slices.Equal(m.Sources, sources) && m.Format == format
+}
+
+// changed 判断文件相对清单是否新增或变化，m 为 nil 时所有文件都视为变化
+func (m *Manifest) changed(name string, info os.FileInfo) bool {
+	if m == nil {
+		return true
+	}
+	entry, ok := m.Files[name]
+	return !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime())
+}
+
+// deleted 返回清单中有、本次备份中没有的文件
+func (m *Manifest) deleted(current map[string]ManifestEntry) []string {
+	var names []string
+	for name := range m.Files {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetBackupFileName 返回增量备份模式下的压缩包文件名，如 app_2024_01_01_full.zip
+func GetBackupFileName(prefix, kind, format string) string {
+	ext, err := ArchiveExtension(format)
+	if err != nil {
+		ext = ".zip"
+	}
+	return GetDefaultProcessor().Generate(prefix, time.Now()) + "_" + kind + ext
+}
+
+// ParseBackupKind 返回文件名中的备份类型，不是增量备份模式生成的文件返回空
+func ParseBackupKind(name string) string {
+	matches := backupKindRegexp.FindStringSubmatch(name)
+	if matches == nil {
+		return ""
+	}
+	return matches[1]
+}
+
+// DependentFullBackups 返回未过期的增量备份所依赖的全量备份文件名，清理历史文件时需要保留；
+// 增量备份依赖日期不晚于它的最近一次全量备份
+func DependentFullBackups(prefix string, names []string) map[string]bool {
+	type dated struct {
+		name string
+		date time.Time
+	}
+
+	var fulls []dated
+	for _, name := range names {
+		if ParseBackupKind(name) != BackupFull {
+			continue
+		}
+		if result, err := GetDefaultProcessor().Parse(name); err == nil {
+			fulls = append(fulls, dated{name: name, date: result.ToTime()})
+		}
+	}
+
+	needed := make(map[string]bool)
+	for _, name := range names {
+		if ParseBackupKind(name) != BackupIncremental || IsNeedDeleteFile(prefix, name) {
+			continue
+		}
+		result, err := GetDefaultProcessor().Parse(name)
+		if err != nil {
+			continue
+		}
+
+		var base *dated
+		for i, full := range fulls {
+			if !full.date.After(result.ToTime()) && (base == nil || full.date.After(base.date)) {
+				base = &fulls[i]
+			}
+		}
+		if base != nil {
+			needed[base.name] = true
+		}
+	}
+	return needed
+}
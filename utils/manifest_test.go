@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncrementalArchive(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "media")
+	write := func(name, content string) {
+		p := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "a")
+	write("b.txt", "b")
+	write("sub/c.txt", "c")
+
+	noop := func(string, int64, int64, float64) {}
+	done := func(int64) {}
+	full, err := ZipToWriter([]string{source}, io.Discard, ArchiveOptions{Format: FormatTarGz, Manifest: true}, noop, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Manifest.Files) != 3 || full.Manifest.Files["media/a.txt"].Hash == "" {
+		t.Fatalf("manifest = %+v", full.Manifest.Files)
+	}
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	full.Manifest.Sources = []string{source}
+	full.Manifest.Format = FormatTarGz
+	if err := full.Manifest.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	base, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !base.Compatible([]string{source}, FormatTarGz) || base.Compatible([]string{source}, FormatZip) {
+		t.Error("compatible check failed")
+	}
+
+	// 修改 a.txt，新增 d.txt，删除 sub/c.txt
+	write("a.txt", "aa")
+	write("d.txt", "d")
+	if err := os.Remove(filepath.Join(source, "sub", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ArchiveOptions{Format: FormatTarGz, Manifest: true, Base: base}
+	size, err := DirSize([]string{source}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 3 {
+		t.Errorf("incremental size = %d, want 3", size)
+	}
+
+	var buf bytes.Buffer
+	inc, err := ZipToWriter([]string{source}, &buf, opts, noop, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inc.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", inc.Deleted)
+	}
+	if inc.Manifest.Files["media/b.txt"] != base.Files["media/b.txt"] {
+		t.Error("unchanged file should keep base entry")
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(tr)
+			got[hdr.Name] = string(data)
+		}
+	}
+	want := map[string]string{
+		"media/a.txt":   "aa",
+		"media/d.txt":   "d",
+		DeletedListName: "media/sub/c.txt\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestDependentFullBackups(t *testing.T) {
+	name := func(daysAgo int, kind string) string {
+		return GetDefaultProcessor().Generate("app", time.Now().AddDate(0, 0, -daysAgo)) + "_" + kind + ".zip"
+	}
+
+	oldFull := name(20, BackupFull)
+	liveFull := name(10, BackupFull)
+	names := []string{
+		oldFull,
+		name(15, BackupIncremental),
+		liveFull,
+		name(9, BackupIncremental),
+		name(2, BackupIncremental),
+		name(1, BackupFull),
+	}
+
+	if ParseBackupKind(liveFull) != BackupFull || ParseBackupKind(names[1]) != BackupIncremental || ParseBackupKind("app_2024_01_01.zip") != "" {
+		t.Error("parse backup kind failed")
+	}
+
+	dependent := DependentFullBackups("app", names)
+	if !dependent[liveFull] || dependent[oldFull] || len(dependent) != 1 {
+		t.Errorf("dependent = %v, want only %s", dependent, liveFull)
+	}
+}
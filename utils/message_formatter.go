@@ -54,11 +54,7 @@ func (f *PlainTextFormatter) formatHeader(taskID string, entries []LogEntry) {
 	fmt.Fprintf(&f.builder, "备份任务: %s\n", taskID)
 
 	// 判断任务状态
-	status := "✓ 成功"
-	if f.hasErrors(entries) {
-		status = "✗ 失败"
-	}
-	fmt.Fprintf(&f.builder, "状态: %s\n", status)
+	fmt.Fprintf(&f.builder, "状态: %s\n", f.taskStatus(entries))
 	f.builder.WriteString("========================================\n\n")
 }
 
@@ -97,6 +93,15 @@ func (f *PlainTextFormatter) extractKeyMetrics(entries []LogEntry) {
 		if entry.Type == LogEntryTypeInfo && strings.Contains(entry.Message, "bucket") {
 			fmt.Fprintf(&f.builder, "  %s\n", entry.Message)
 		}
+		if entry.Type == LogEntryTypeInfo && strings.Contains(entry.Message, "个无法备份的文件") {
+			fmt.Fprintf(&f.builder, "  %s\n", entry.Message)
+		}
+		if entry.Type == LogEntryTypeInfo && strings.Contains(entry.Message, "个文件在备份过程中发生变化") {
+			fmt.Fprintf(&f.builder, "  %s\n", entry.Message)
+		}
+		if entry.Type == LogEntryTypeInfo && strings.Contains(entry.Message, "上传结果") {
+			fmt.Fprintf(&f.builder, "  %s\n", entry.Message)
+		}
 	}
 }
 
@@ -105,7 +110,7 @@ func (f *PlainTextFormatter) formatErrors(entries []LogEntry) {
 	f.builder.WriteString("❌ 错误信息\n")
 
 	for _, entry := range entries {
-		if entry.Type == LogEntryTypeError || (entry.Type == LogEntryTypeStep && entry.StepStatus == StepStatusFailed) {
+		if entry.Type == LogEntryTypeError || (entry.Type == LogEntryTypeStep && (entry.StepStatus == StepStatusFailed || entry.StepStatus == StepStatusPartial)) {
 			if entry.StepName != "" {
 				fmt.Fprintf(&f.builder, "  步骤: %s\n", entry.StepName)
 			}
@@ -118,6 +123,11 @@ func (f *PlainTextFormatter) formatErrors(entries []LogEntry) {
 			fmt.Fprintf(&f.builder, "  时间: %s\n", FormatTimestamp(entry.Timestamp))
 			f.builder.WriteString("\n")
 		}
+		if entry.Type == LogEntryTypeWarning {
+			fmt.Fprintf(&f.builder, "  警告: %s\n", entry.Message)
+			fmt.Fprintf(&f.builder, "  时间: %s\n", FormatTimestamp(entry.Timestamp))
+			f.builder.WriteString("\n")
+		}
 	}
 }
 
@@ -148,6 +158,9 @@ func (f *PlainTextFormatter) formatDetails(startTime time.Time, entries []LogEnt
 
 		case LogEntryTypeError:
 			f.formatErrorEntry(entry, stepDepth)
+
+		case LogEntryTypeWarning:
+			f.formatWarningEntry(entry, stepDepth)
 		}
 	}
 }
@@ -183,6 +196,18 @@ func (f *PlainTextFormatter) formatStepEntry(entry LogEntry, relativeTime string
 			fmt.Fprintf(&f.builder, "%s    错误: %v\n", indent, entry.Error)
 		}
 		f.builder.WriteString("\n")
+
+	case StepStatusPartial:
+		*stepDepth--
+		if *stepDepth < 0 {
+			*stepDepth = 0
+		}
+		indent := strings.Repeat("  ", *stepDepth)
+		fmt.Fprintf(&f.builder, "%s  ⚠ %s部分失败\n", indent, entry.StepName)
+		if entry.Error != nil {
+			fmt.Fprintf(&f.builder, "%s    错误: %v\n", indent, entry.Error)
+		}
+		f.builder.WriteString("\n")
 	}
 }
 
@@ -207,10 +232,53 @@ func (f *PlainTextFormatter) formatErrorEntry(entry LogEntry, stepDepth int) {
 	}
 }
 
-// hasErrors 检查日志条目中是否有错误
+// formatWarningEntry 格式化警告日志条目
+func (f *PlainTextFormatter) formatWarningEntry(entry LogEntry, stepDepth int) {
+	indent := strings.Repeat("  ", stepDepth)
+	fmt.Fprintf(&f.builder, "%s  ⚠ %s\n", indent, entry.Message)
+}
+
+// taskStatus 计算任务整体状态，部分失败步骤内部的错误不算作整体失败
+func (f *PlainTextFormatter) taskStatus(entries []LogEntry) string {
+	// failures[i] 为第 i 层未结束步骤内累计的错误数
+	failures := []int{0}
+	partial := false
+	for _, entry := range entries {
+		top := len(failures) - 1
+		switch {
+		case entry.Type == LogEntryTypeError:
+			failures[top]++
+		case entry.Type == LogEntryTypeStep && entry.StepStatus == StepStatusStart:
+			failures = append(failures, 0)
+		case entry.Type == LogEntryTypeStep && top > 0:
+			count := failures[top]
+			failures = failures[:top]
+			switch entry.StepStatus {
+			case StepStatusFailed:
+				failures[top-1] += count + 1
+			case StepStatusPartial:
+				partial = true
+			default:
+				failures[top-1] += count
+			}
+		}
+	}
+
+	for _, count := range failures {
+		if count > 0 {
+			return "✗ 失败"
+		}
+	}
+	if partial {
+		return "⚠ 部分失败"
+	}
+	return "✓ 成功"
+}
+
+// hasErrors 检查日志条目中是否有错误或警告
 func (f *PlainTextFormatter) hasErrors(entries []LogEntry) bool {
 	for _, entry := range entries {
-		if entry.Type == LogEntryTypeError || (entry.Type == LogEntryTypeStep && entry.StepStatus == StepStatusFailed) {
+		if entry.Type == LogEntryTypeError || entry.Type == LogEntryTypeWarning || (entry.Type == LogEntryTypeStep && (entry.StepStatus == StepStatusFailed || entry.StepStatus == StepStatusPartial)) {
 			return true
 		}
 	}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlainTextFormatter_PartialStatus(t *testing.T) {
+	logger := NewTaskLogger("test")
+	logger.ExecuteStep("上传", func() error {
+		logger.ExecuteStep("上传到oss", func() error {
+			return nil
+		})
+		logger.ExecuteStep("上传到nas", func() error {
+			err := errors.New("disk full")
+			logger.LogError(err, "上传失败")
+			return err
+		})
+		return fmt.Errorf("%w: 1/2 storages upload failed", ErrPartialFailed)
+	})
+
+	message := NewPlainTextFormatter(false).Format("test", logger.GetStartTime(), logger.GetEntries())
+	if !strings.Contains(message, "状态: ⚠ 部分失败") {
+		t.Fatalf("expect partial status, got\n%s", message)
+	}
+
+	logger.ExecuteStep("清理历史文件", func() error {
+		return errors.New("list failed")
+	})
+	message = NewPlainTextFormatter(false).Format("test", logger.GetStartTime(), logger.GetEntries())
+	if !strings.Contains(message, "状态: ✗ 失败") {
+		t.Fatalf("expect failed status, got\n%s", message)
+	}
+}
+
+func TestPlainTextFormatter_Warnings(t *testing.T) {
+	logger := NewTaskLogger("test")
+	logger.ExecuteStep("压缩文件", func() error {
+		logger.LogInfo("1 个文件在备份过程中发生变化，内容可能不一致")
+		logger.LogWarning("/data/app.log: 读取过程中大小从 10 变为 20")
+		return nil
+	})
+
+	message := NewPlainTextFormatter(false).Format("test", logger.GetStartTime(), logger.GetEntries())
+	if !strings.Contains(message, "状态: ✓ 成功") {
+		t.Fatalf("warnings should not fail the task, got\n%s", message)
+	}
+	errorSection := message[strings.Index(message, "❌ 错误信息"):strings.Index(message, "📝 执行详情")]
+	if !strings.Contains(errorSection, "警告: /data/app.log: 读取过程中大小从 10 变为 20") {
+		t.Fatalf("expect warning in error section, got\n%s", message)
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultKeyTemplate 默认直接使用文件名作为对象 key
+const DefaultKeyTemplate = "{name}"
+
+// KeyTemplate 对象 key 模板，支持 {host}、{task}、{yyyy}、{mm}、{dd}、{name}，
+// 日期取自文件名，多台主机共享 bucket 时可以用 {host} 区分
+type KeyTemplate struct {
+	template string
+	task     string
+	host     string
+}
+
+// NewKeyTemplate 创建任务的 key 模板，模板为空时使用 DefaultKeyTemplate
+func NewKeyTemplate(template, task string) KeyTemplate {
+	template = strings.Trim(template, "/")
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+
+	return KeyTemplate{
+		template: template,
+		task:     task,
+		host:     strings.ReplaceAll(host, "/", "_"),
+	}
+}
+
+// ValidateKeyTemplate 检查模板是否包含 {name}，否则同一任务的不同备份会互相覆盖
+func ValidateKeyTemplate(template string) error {
+	if template != "" && !strings.Contains(template, "{name}") {
+		return fmt.Errorf("key template %q must contain {name}", template)
+	}
+	return nil
+}
+
+// Key 返回文件名对应的对象 key
+func (kt KeyTemplate) Key(name string) string {
+	t := time.Now()
+	if result, err := GetDefaultProcessor().Parse(name); err == nil {
+		t = result.ToTime()
+	}
+
+	return strings.NewReplacer(
+		"{host}", kt.host,
+		"{task}", kt.task,
+		"{yyyy}", fmt.Sprintf("%04d", t.Year()),
+		"{mm}", fmt.Sprintf("%02d", t.Month()),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+		"{name}", name,
+	).Replace(kt.template)
+}
+
+// Prefix 返回模板中日期和文件名之前的固定部分，用于只列出该任务的对象
+func (kt KeyTemplate) Prefix() string {
+	static := kt.template
+	for _, v := range []string{"{yyyy}", "{mm}", "{dd}", "{name}"} {
+		if i := strings.Index(static, v); i >= 0 {
+			static = static[:i]
+		}
+	}
+
+	return strings.NewReplacer("{host}", kt.host, "{task}", kt.task).Replace(static)
+}
+
+// Match 判断对象 key 是否由该模板生成，避免误删其他主机或任务的文件
+func (kt KeyTemplate) Match(key string) bool {
+	return kt.Key(path.Base(key)) == key
+}
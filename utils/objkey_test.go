@@ -0,0 +1,35 @@
+package utils
+
+import "testing"
+
+func TestKeyTemplate(t *testing.T) {
+	kt := KeyTemplate{template: "{host}/{task}/{yyyy}/{mm}/{name}", task: "app", host: "web1"}
+
+	name := "app_2024_03_05.zip"
+	if got := kt.Key(name); got != "web1/app/2024/03/app_2024_03_05.zip" {
+		t.Fatalf("key got %s", got)
+	}
+	if got := kt.Prefix(); got != "web1/app/" {
+		t.Fatalf("prefix got %s", got)
+	}
+
+	for key, want := range map[string]bool{
+		"web1/app/2024/03/app_2024_03_05.zip": true,
+		"web2/app/2024/03/app_2024_03_05.zip": false,
+		"web1/app/2024/04/app_2024_03_05.zip": false,
+		"app_2024_03_05.zip":                  false,
+	} {
+		if got := kt.Match(key); got != want {
+			t.Errorf("match %s got %v, want %v", key, got, want)
+		}
+	}
+
+	root := NewKeyTemplate("", "app")
+	if root.Key(name) != name || root.Prefix() != "" || !root.Match(name) {
+		t.Fatalf("default template should keep file name at root")
+	}
+
+	if err := ValidateKeyTemplate("{host}/{task}"); err == nil {
+		t.Fatal("template without {name} should be invalid")
+	}
+}
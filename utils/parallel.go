@@ -0,0 +1,418 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// spillThreshold 并行压缩时单个文件压缩后超过该大小改写到临时文件，限制内存占用
+const spillThreshold = 4 * 1024 * 1024
+
+// spillBuffer 先写入内存，超过 spillThreshold 后全部转到临时文件
+type spillBuffer struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (sb *spillBuffer) Write(p []byte) (int, error) {
+	if sb.file == nil && sb.buf.Len()+len(p) > spillThreshold {
+		file, err := os.CreateTemp("", "backup-zip-*")
+		if err != nil {
+			return 0, err
+		}
+		sb.file = file
+		if _, err := sb.buf.WriteTo(file); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if sb.file != nil {
+		n, err = sb.file.Write(p)
+	} else {
+		n, err = sb.buf.Write(p)
+	}
+	sb.size += int64(n)
+	return n, err
+}
+
+// reader 返回写入内容的读取器
+func (sb *spillBuffer) reader() (io.Reader, error) {
+	if sb.file == nil {
+		return &sb.buf, nil
+	}
+	if _, err := sb.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return sb.file, nil
+}
+
+// release 释放内存并删除临时文件
+func (sb *spillBuffer) release() {
+	sb.buf = bytes.Buffer{}
+	if sb.file != nil {
+		sb.file.Close()
+		os.Remove(sb.file.Name())
+		sb.file = nil
+	}
+}
+
+// zipJob 并行压缩中的一个条目，目录和符号链接不需要压缩，file 为空
+type zipJob struct {
+	path string
+	name string
+	info os.FileInfo
+	file *os.File
+	// hash 为 true 时计算文件内容的 SHA256，结果在 sum 中
+	hash bool
+	sum  string
+	// crc、size 为写入内容的 CRC32 和大小，out 为压缩后的数据
+	crc  uint32
+	size int64
+	out  spillBuffer
+	// changed 读取过程中文件变化的说明，stat 为清单中记录的文件信息
+	changed string
+	stat    os.FileInfo
+	err     error
+	done    chan struct{}
+}
+
+// parallelZip 多个 worker 同时压缩文件，写入协程按遍历顺序把压缩好的数据原样写入 zip，
+// 压缩包内容和顺序与单线程一致；进度按 worker 读取的原始数据统计
+type parallelZip struct {
+	archive *zipArchiveWriter
+	tracker *ProgressTracker
+	policy  string
+	work    chan *zipJob
+	ordered chan *zipJob
+	workers sync.WaitGroup
+	written chan struct{}
+	flaters sync.Pool
+	// changed 读取过程中发生变化的文件，按写入顺序排列，close 后才能读取
+	changed []SkippedFile
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelZip(archive *zipArchiveWriter, tracker *ProgressTracker, parallel int, policy string) *parallelZip {
+	pz := &parallelZip{
+		archive: archive,
+		tracker: tracker,
+		policy:  policy,
+		work:    make(chan *zipJob, parallel),
+		// 等待写入的条目数有上限，写入慢时遍历会阻塞，内存中最多保留这些文件的压缩数据
+		ordered: make(chan *zipJob, parallel*2),
+		written: make(chan struct{}),
+	}
+	for i := 0; i < parallel; i++ {
+		pz.workers.Add(1)
+		go func() {
+			defer pz.workers.Done()
+			for job := range pz.work {
+				job.err = pz.compress(job)
+				close(job.done)
+			}
+		}()
+	}
+	go pz.writeLoop()
+	return pz
+}
+
+// add 提交一个条目，文件在这里打开，无法读取时按 unreadableError 返回，和单线程一样可以跳过
+func (pz *parallelZip) add(path, name string, info os.FileInfo, hash bool) (*zipJob, error) {
+	if err := pz.failed(); err != nil {
+		return nil, err
+	}
+
+	job := &zipJob{path: path, name: name, info: info, hash: hash, done: make(chan struct{})}
+	if info.Mode().IsRegular() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, &unreadableError{err: err}
+		}
+		job.file = f
+	}
+
+	pz.ordered <- job
+	if job.file != nil {
+		pz.work <- job
+	} else {
+		close(job.done)
+	}
+	return job, nil
+}
+
+// close 等待所有条目写入，返回第一个错误
+func (pz *parallelZip) close() error {
+	close(pz.work)
+	close(pz.ordered)
+	<-pz.written
+	pz.workers.Wait()
+	return pz.failed()
+}
+
+func (pz *parallelZip) failed() error {
+	pz.mu.Lock()
+	defer pz.mu.Unlock()
+	return pz.err
+}
+
+func (pz *parallelZip) fail(err error) {
+	pz.mu.Lock()
+	defer pz.mu.Unlock()
+	if pz.err == nil {
+		pz.err = err
+	}
+}
+
+func (pz *parallelZip) compress(job *zipJob) error {
+	defer job.file.Close()
+	pz.tracker.UpdateCurrentFile(job.path)
+
+	var fw *flate.Writer
+	if pz.archive.method == zip.Deflate {
+		fw, _ = pz.flaters.Get().(*flate.Writer)
+		if fw == nil {
+			var err error
+			if fw, err = flate.NewWriter(io.Discard, pz.archive.level); err != nil {
+				return err
+			}
+		}
+		defer pz.flaters.Put(fw)
+	}
+
+	// 文件变化重新读取时丢弃之前的压缩数据和校验值
+	crc := crc32.NewIEEE()
+	var h hash.Hash
+	if job.hash {
+		h = sha256.New()
+	}
+	counter := &countWriter{}
+	before, after, changed, err := readStable(job.file, pz.policy, pz.tracker, func() io.Writer {
+		job.out.release()
+		job.out.size = 0
+		crc.Reset()
+		counter.n = 0
+		writers := []io.Writer{&job.out, crc, counter}
+		if fw != nil {
+			fw.Reset(&job.out)
+			writers[0] = fw
+		}
+		if h != nil {
+			h.Reset()
+			writers = append(writers, h)
+		}
+		return io.MultiWriter(writers...)
+	})
+	if err != nil {
+		return err
+	}
+	if fw != nil {
+		if err := fw.Close(); err != nil {
+			return fmt.Errorf("write file failed: %w", err)
+		}
+	}
+	if changed != "" && pz.policy == PolicyFail {
+		return ChangedError(job.path, changed)
+	}
+
+	job.info = before
+	job.stat = manifestStat(before, after, changed)
+	job.changed = changed
+	job.crc = crc.Sum32()
+	job.size = counter.n
+	if h != nil {
+		job.sum = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+// countWriter 统计写入的字节数
+type countWriter struct {
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// writeLoop 按提交顺序写入条目，出错后继续取出剩余条目并释放，避免遍历和 worker 阻塞
+func (pz *parallelZip) writeLoop() {
+	defer close(pz.written)
+	for job := range pz.ordered {
+		<-job.done
+		if pz.failed() == nil {
+			err := job.err
+			if err == nil {
+				err = pz.write(job)
+			}
+			if err != nil {
+				pz.fail(err)
+			} else if job.changed != "" {
+				pz.changed = append(pz.changed, SkippedFile{Path: job.path, Reason: job.changed})
+			}
+		}
+		job.out.release()
+	}
+}
+
+func (pz *parallelZip) write(job *zipJob) error {
+	if job.file == nil {
+		if _, err := pz.archive.WriteHeader(job.path, job.name, job.info); err != nil {
+			return fmt.Errorf("create header failed: %w", err)
+		}
+		return nil
+	}
+
+	header, err := pz.archive.header(job.name, job.info)
+	if err != nil {
+		return fmt.Errorf("create header failed: %w", err)
+	}
+	header.CRC32 = job.crc
+	header.UncompressedSize64 = uint64(job.size)
+	header.CompressedSize64 = uint64(job.out.size)
+	writer, err := pz.archive.zw.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("create header failed: %w", err)
+	}
+
+	r, err := job.out.reader()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		return fmt.Errorf("write file failed: %w", err)
+	}
+	return nil
+}
+
+// gzipBlockSize 并行 gzip 每个数据块的大小
+const gzipBlockSize = 1024 * 1024
+
+// gzipBlock 一个数据块及其压缩结果
+type gzipBlock struct {
+	data []byte
+	out  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// parallelGzipWriter 把数据分块并行压缩为连续的多个 gzip 成员，gzip、tar 等工具会依次解压所有成员，
+// 块之间不共享字典，压缩率略低于单线程
+type parallelGzipWriter struct {
+	w       io.Writer
+	level   int
+	buf     []byte
+	sem     chan struct{}
+	ordered chan *gzipBlock
+	written chan struct{}
+	started bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelGzipWriter(w io.Writer, level, parallel int) *parallelGzipWriter {
+	gw := &parallelGzipWriter{
+		w:       w,
+		level:   level,
+		buf:     make([]byte, 0, gzipBlockSize),
+		sem:     make(chan struct{}, parallel),
+		ordered: make(chan *gzipBlock, parallel),
+		written: make(chan struct{}),
+	}
+	go gw.writeLoop()
+	return gw
+}
+
+func (gw *parallelGzipWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := gw.failed(); err != nil {
+			return written, err
+		}
+		n := min(len(p), gzipBlockSize-len(gw.buf))
+		gw.buf = append(gw.buf, p[:n]...)
+		written += n
+		p = p[n:]
+		if len(gw.buf) >= gzipBlockSize {
+			gw.flush()
+		}
+	}
+	return written, nil
+}
+
+// flush 提交当前数据块并行压缩
+func (gw *parallelGzipWriter) flush() {
+	block := &gzipBlock{data: gw.buf, done: make(chan struct{})}
+	gw.buf = make([]byte, 0, gzipBlockSize)
+	gw.started = true
+
+	gw.ordered <- block
+	gw.sem <- struct{}{}
+	go func() {
+		defer func() {
+			<-gw.sem
+			close(block.done)
+		}()
+		zw, err := gzip.NewWriterLevel(&block.out, gw.level)
+		if err != nil {
+			block.err = err
+			return
+		}
+		if _, err := zw.Write(block.data); err != nil {
+			block.err = err
+			return
+		}
+		block.err = zw.Close()
+	}()
+}
+
+func (gw *parallelGzipWriter) writeLoop() {
+	defer close(gw.written)
+	for block := range gw.ordered {
+		<-block.done
+		if gw.failed() != nil {
+			continue
+		}
+		err := block.err
+		if err == nil {
+			_, err = gw.w.Write(block.out.Bytes())
+		}
+		if err != nil {
+			gw.mu.Lock()
+			gw.err = err
+			gw.mu.Unlock()
+		}
+	}
+}
+
+func (gw *parallelGzipWriter) failed() error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.err
+}
+
+// Close 压缩剩余数据并等待全部写入，没有数据时也写入一个空成员，保证输出是合法的 gzip
+func (gw *parallelGzipWriter) Close() error {
+	if len(gw.buf) > 0 || !gw.started {
+		gw.flush()
+	}
+	close(gw.ordered)
+	<-gw.written
+	return gw.failed()
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readArchive 解压压缩包，返回文件内容和 zip 条目的压缩方式
+func readArchive(t *testing.T, format string, data []byte) (map[string]string, map[string]uint16) {
+	t.Helper()
+	contents := make(map[string]string)
+	methods := make(map[string]uint16)
+	if format == FormatZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			methods[f.Name] = f.Method
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("read %s: %v", f.Name, err)
+			}
+			contents[f.Name] = string(b)
+		}
+		return contents, methods
+	}
+
+	var r io.Reader
+	var err error
+	if format == FormatTarGz {
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	} else {
+		r, err = zstd.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[header.Name] = string(b)
+		case tar.TypeSymlink:
+			contents[header.Name] = header.Linkname
+		}
+	}
+	return contents, methods
+}
+
+func TestParallelArchive(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data")
+	want := make(map[string]string)
+	write := func(name, content string) {
+		p := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		want["data/"+name] = content
+	}
+	for i := 0; i < 50; i++ {
+		write(fmt.Sprintf("dir%d/file%d.txt", i%5, i), strings.Repeat(fmt.Sprintf("line %d\n", i), i*100))
+	}
+	// 压缩后超过 spillThreshold 的文件写到临时文件
+	big := make([]byte, 6*1024*1024)
+	if _, err := rand.Read(big); err != nil {
+		t.Fatal(err)
+	}
+	write("big.bin", string(big))
+	if err := os.Symlink("big.bin", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	want["data/link"] = "big.bin"
+
+	noop := func(string, int64, int64, float64) {}
+	for _, format := range []string{FormatZip, FormatTarGz, FormatTarZst} {
+		for _, level := range []string{"", LevelStore, LevelBest} {
+			var hashes map[string]ManifestEntry
+			for _, parallel := range []int{0, 4} {
+				t.Run(fmt.Sprintf("%s/%s/%d", format, level, parallel), func(t *testing.T) {
+					var buf bytes.Buffer
+					var total int64
+					opts := ArchiveOptions{Format: format, Level: level, Parallel: parallel, Manifest: true}
+					archive, err := ZipToWriter([]string{source}, &buf, opts, noop, func(n int64) { total = n })
+					if err != nil {
+						t.Fatal(err)
+					}
+					if archive.Size != int64(buf.Len()) || total <= int64(len(big)) {
+						t.Fatalf("size = %d, written = %d, total = %d", archive.Size, buf.Len(), total)
+					}
+
+					contents, methods := readArchive(t, format, buf.Bytes())
+					if len(contents) != len(want) {
+						t.Fatalf("entries = %d, want %d", len(contents), len(want))
+					}
+					for name, content := range want {
+						if contents[name] != content {
+							t.Fatalf("content of %s mismatch", name)
+						}
+					}
+					if format == FormatZip {
+						wantMethod := zip.Deflate
+						if level == LevelStore {
+							wantMethod = zip.Store
+						}
+						if methods["data/big.bin"] != wantMethod {
+							t.Errorf("method = %d, want %d", methods["data/big.bin"], wantMethod)
+						}
+					}
+
+					// 并行压缩的文件清单与单线程一致
+					if hashes == nil {
+						hashes = archive.Manifest.Files
+						return
+					}
+					for name, entry := range hashes {
+						if got := archive.Manifest.Files[name]; got.Hash != entry.Hash {
+							t.Errorf("hash of %s = %q, want %q", name, got.Hash, entry.Hash)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCompressionLevelValidate(t *testing.T) {
+	if err := (ArchiveOptions{Level: "ultra"}).Validate(); err == nil {
+		t.Error("invalid level should fail")
+	}
+	if err := (ArchiveOptions{Parallel: -1}).Validate(); err == nil {
+		t.Error("negative parallel should fail")
+	}
+	if err := (ArchiveOptions{Level: LevelStore, Parallel: 8}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
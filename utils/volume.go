@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var volumeRegexp = regexp.MustCompile(`\.\d{3,}$`)
+
+// VolumeSuffix 返回第 n 个分卷的后缀，从 1 开始，如 .001
+func VolumeSuffix(n int) string {
+	return fmt.Sprintf(".%03d", n)
+}
+
+// TrimVolumeSuffix 去掉分卷后缀，同一备份的所有分卷返回相同的名称
+func TrimVolumeSuffix(name string) string {
+	return volumeRegexp.ReplaceAllString(name, "")
+}
+
+// VolumeFunc 每个分卷写完后调用，返回错误时停止压缩
+type VolumeFunc func(volume *ArchiveInfo) error
+
+// volumeWriter 把数据按 size 切分依次写入 base.001、base.002 ...
+type volumeWriter struct {
+	base     string
+	size     int64
+	onVolume VolumeFunc
+	file     *os.File
+	cw       *checksumWriter
+	volumes  []*ArchiveInfo
+}
+
+func (vw *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if vw.file == nil {
+			file, err := os.Create(vw.base + VolumeSuffix(len(vw.volumes)+1))
+			if err != nil {
+				return written, err
+			}
+			vw.file, vw.cw = file, newChecksumWriter(file)
+		}
+
+		n := min(int64(len(p)), vw.size-vw.cw.size)
+		w, err := vw.cw.Write(p[:n])
+		written += w
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		if vw.cw.size >= vw.size {
+			if err := vw.finish(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// finish 关闭当前分卷并回调
+func (vw *volumeWriter) finish() error {
+	file := vw.file
+	vw.file = nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	info := vw.cw.archiveInfo()
+	info.Path = file.Name()
+	vw.volumes = append(vw.volumes, info)
+	if vw.onVolume != nil {
+		return vw.onVolume(info)
+	}
+	return nil
+}
+
+// ZipVolumes 压缩目录和文件，按 size 分卷写入 target.001、target.002 ...，
+// onVolume 不为空时每个分卷写完后立即调用，可用于边压缩边上传；
+// 返回的 ArchiveInfo 校验值按整个压缩包计算，Volumes 为各分卷信息，失败时删除已生成的分卷
+func ZipVolumes(sources []string, target string, size int64, opts ArchiveOptions, onVolume VolumeFunc, callback ProgressCallback, doneCallback ProgressDoneCallback) (*ArchiveInfo, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid volume size %d", size)
+	}
+	target = filepath.Clean(target)
+	log.Printf("zip path: %s, target: %s, volume size: %d", strings.Join(sources, ", "), target, size)
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("create target directory failed: %w", err)
+	}
+
+	vw := &volumeWriter{base: target, size: size, onVolume: onVolume}
+	archiveInfo, err := ZipToWriter(sources, vw, opts, callback, doneCallback)
+	if err == nil && vw.file != nil {
+		err = vw.finish()
+	}
+	if err != nil {
+		if vw.file != nil {
+			vw.file.Close()
+			os.Remove(vw.file.Name())
+		}
+		for _, volume := range vw.volumes {
+			os.Remove(volume.Path)
+		}
+		return nil, err
+	}
+
+	archiveInfo.Path = target
+	archiveInfo.Volumes = vw.volumes
+	return archiveInfo, nil
+}
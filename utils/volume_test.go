@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipVolumes(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 300*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "random.bin"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	noop := func(string, int64, int64, float64) {}
+	done := func(int64) {}
+	target := filepath.Join(t.TempDir(), "data.zip")
+	var called []string
+	archive, err := ZipVolumes([]string{source}, target, 100*1024, ArchiveOptions{}, func(volume *ArchiveInfo) error {
+		called = append(called, filepath.Base(volume.Path))
+		return nil
+	}, noop, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archive.Volumes) != 4 || len(called) != 4 || called[0] != "data.zip.001" || called[3] != "data.zip.004" {
+		t.Fatalf("volumes = %d, called = %v", len(archive.Volumes), called)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target should not exist, err = %v", err)
+	}
+
+	// 分卷按顺序拼接后是完整的压缩包
+	var joined bytes.Buffer
+	for i, volume := range archive.Volumes {
+		if i < len(archive.Volumes)-1 && volume.Size != 100*1024 {
+			t.Errorf("volume %d size = %d", i+1, volume.Size)
+		}
+		data, err := os.ReadFile(volume.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != volume.SHA256 {
+			t.Errorf("volume %d checksum mismatch", i+1)
+		}
+		joined.Write(data)
+	}
+	sum := sha256.Sum256(joined.Bytes())
+	if int64(joined.Len()) != archive.Size || hex.EncodeToString(sum[:]) != archive.SHA256 {
+		t.Fatal("joined volumes do not match archive checksum")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(joined.Bytes()), int64(joined.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "data/random.bin" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, content) {
+			t.Fatalf("restored content mismatch, err = %v", err)
+		}
+	}
+
+	// 回调失败时停止压缩并删除已生成的分卷
+	stop := errors.New("stop")
+	target = filepath.Join(t.TempDir(), "fail.zip")
+	if _, err := ZipVolumes([]string{source}, target, 100*1024, ArchiveOptions{}, func(*ArchiveInfo) error {
+		return stop
+	}, noop, done); !errors.Is(err, stop) {
+		t.Fatalf("err = %v, want stop", err)
+	}
+	if matches, _ := filepath.Glob(target + ".*"); len(matches) > 0 {
+		t.Errorf("volumes left after failure: %v", matches)
+	}
+}
+
+func TestTrimVolumeSuffix(t *testing.T) {
+	tests := map[string]string{
+		"app_2024_01_01.zip.001":          "app_2024_01_01.zip",
+		"app_2024_01_01_full.tar.zst.012": "app_2024_01_01_full.tar.zst",
+		"app_2024_01_01.zip.age.1000":     "app_2024_01_01.zip.age",
+		"app_2024_01_01.zip":              "app_2024_01_01.zip",
+		"app_2024_01_01.zip.01":           "app_2024_01_01.zip.01",
+	}
+	for name, want := range tests {
+		if got := TrimVolumeSuffix(name); got != want {
+			t.Errorf("TrimVolumeSuffix(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 符号链接处理方式
+const (
+	// SymlinkStore 保存链接本身，默认
+	SymlinkStore = "store"
+	// SymlinkFollow 保存链接指向的文件或目录
+	SymlinkFollow = "follow"
+)
+
+// 特殊文件、无法读取文件的处理方式
+const (
+	PolicySkip = "skip"
+	PolicyFail = "fail"
+)
+
+// SkippedFile 因特殊文件、无法读取等原因未备份的文件
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// unreadableError 打开文件失败，此时还未写入条目，可以按策略跳过
+type unreadableError struct {
+	err error
+}
+
+func (e *unreadableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unreadableError) Unwrap() error {
+	return e.err
+}
+
+// NewUnreadableError 包装打开文件失败的错误，WalkSources 的回调返回该错误时按 Unreadable 策略跳过或失败
+func NewUnreadableError(err error) error {
+	return &unreadableError{err: err}
+}
+
+// Validate 检查符号链接、特殊文件和无法读取文件的处理方式
+func (opts ArchiveOptions) Validate() error {
+	if _, err := ArchiveExtension(opts.Format); err != nil {
+		return err
+	}
+	if opts.Symlinks != "" && opts.Symlinks != SymlinkStore && opts.Symlinks != SymlinkFollow {
+		return fmt.Errorf("unsupported symlinks policy %s, support store, follow", opts.Symlinks)
+	}
+	if err := validateLevel(opts.Level); err != nil {
+		return err
+	}
+	if err := validateChangedPolicy(opts.Changed); err != nil {
+		return err
+	}
+	if opts.Parallel < 0 {
+		return fmt.Errorf("invalid parallel %d", opts.Parallel)
+	}
+	for _, policy := range []string{opts.SpecialFiles, opts.Unreadable} {
+		if policy != "" && policy != PolicySkip && policy != PolicyFail {
+			return fmt.Errorf("unsupported file policy %s, support skip, fail", policy)
+		}
+	}
+	return nil
+}
+
+// WalkSources 按压缩时的规则遍历多个源路径，name 为文件在压缩包中的路径，
+// 返回按排除规则跳过的数量和按策略跳过的文件
+func WalkSources(sources []string, opts ArchiveOptions, fn func(path, name string, info os.FileInfo) error) (int, []SkippedFile, error) {
+	resolved, err := resolveSources(sources)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var excluded int
+	var skipped []SkippedFile
+	for _, src := range resolved {
+		result, err := walkSource(src.path, opts, func(path, rel string, info os.FileInfo) error {
+			return fn(path, filepath.ToSlash(filepath.Join(src.name, rel)), info)
+		})
+		excluded += result.excluded
+		skipped = append(skipped, result.skipped...)
+		if err != nil {
+			return excluded, skipped, err
+		}
+	}
+	return excluded, skipped, nil
+}
+
+// walkResult 遍历时跳过的文件
+type walkResult struct {
+	// excluded 按排除规则跳过的文件和目录数
+	excluded int
+	skipped  []SkippedFile
+}
+
+// sourceWalker 遍历源路径，按选项处理排除规则、符号链接、特殊文件和无法读取的文件
+type sourceWalker struct {
+	opts   ArchiveOptions
+	filter *pathFilter
+	fn     func(path, rel string, info os.FileInfo) error
+	result walkResult
+	// ancestors follow 模式下当前路径上已进入的目录，避免链接成环
+	ancestors map[string]bool
+	// pending 配置了 include 时还没有写入的上级目录，遇到保留的文件时才按顺序写入，
+	// 不包含保留文件的目录不写入空目录
+	pending []pendingDir
+}
+
+type pendingDir struct {
+	path string
+	rel  string
+	info os.FileInfo
+}
+
+// walkSource 遍历源目录或文件，fn 的 rel 为相对源路径的路径，源路径本身为 "."；
+// 源路径是符号链接时总是跟随
+func walkSource(source string, opts ArchiveOptions, fn func(path, rel string, info os.FileInfo) error) (walkResult, error) {
+	w := &sourceWalker{
+		opts:      opts,
+		filter:    newPathFilter(source, opts.Exclude, opts.Include),
+		fn:        fn,
+		ancestors: make(map[string]bool),
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return w.result, fmt.Errorf("walk failed: %w", err)
+	}
+	err = w.walk(source, ".", info)
+	return w.result, err
+}
+
+func (w *sourceWalker) walk(path, rel string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 && w.opts.Symlinks == SymlinkFollow {
+		target, err := os.Stat(path)
+		if err != nil {
+			return w.unreadable(path, err)
+		}
+		info = target
+	}
+
+	skip, err := w.filter.skip(rel, info.IsDir())
+	if err != nil {
+		return fmt.Errorf("read %s failed: %w", IgnoreFileName, err)
+	}
+	if skip {
+		w.result.excluded++
+		return nil
+	}
+
+	switch {
+	case info.IsDir():
+		return w.walkDir(path, rel, info)
+	case info.Mode().IsRegular(), info.Mode()&os.ModeSymlink != 0:
+		err := w.emit(path, rel, info)
+		var ue *unreadableError
+		if errors.As(err, &ue) {
+			return w.unreadable(path, ue.err)
+		}
+		return err
+	default:
+		// socket、FIFO、设备文件
+		if w.opts.SpecialFiles == PolicyFail {
+			return fmt.Errorf("special file %s (%s)", path, info.Mode().Type())
+		}
+		w.result.skipped = append(w.result.skipped, SkippedFile{Path: path, Reason: "特殊文件 " + info.Mode().Type().String()})
+		return nil
+	}
+}
+
+func (w *sourceWalker) walkDir(path, rel string, info os.FileInfo) error {
+	if w.opts.Symlinks == SymlinkFollow {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return w.unreadable(path, err)
+		}
+		if w.ancestors[real] {
+			w.result.skipped = append(w.result.skipped, SkippedFile{Path: path, Reason: "符号链接成环"})
+			return nil
+		}
+		w.ancestors[real] = true
+		defer delete(w.ancestors, real)
+	}
+
+	// 目录本身或上级目录匹配 include 时直接写入，否则等到有保留的文件时再写入
+	deferred := !w.filter.included(rel, true)
+	if deferred {
+		w.pending = append(w.pending, pendingDir{path: path, rel: rel, info: info})
+	} else if err := w.emit(path, rel, info); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return w.unreadable(path, err)
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		childInfo, err := os.Lstat(child)
+		if err != nil {
+			if err := w.unreadable(child, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(child, filepath.Join(rel, entry.Name()), childInfo); err != nil {
+			return err
+		}
+	}
+
+	// 下级有保留的文件时 pending 已经清空，否则栈顶是当前目录
+	if deferred && len(w.pending) > 0 {
+		w.pending = w.pending[:len(w.pending)-1]
+	}
+	return nil
+}
+
+// emit 先写入还没有写入的上级目录，再写入当前条目
+func (w *sourceWalker) emit(path, rel string, info os.FileInfo) error {
+	for _, dir := range w.pending {
+		if err := w.fn(dir.path, dir.rel, dir.info); err != nil {
+			return err
+		}
+	}
+	w.pending = w.pending[:0]
+	return w.fn(path, rel, info)
+}
+
+// unreadable 按策略处理无法读取的文件或目录，默认失败
+func (w *sourceWalker) unreadable(path string, err error) error {
+	if w.opts.Unreadable != PolicySkip {
+		return fmt.Errorf("walk failed: %w", err)
+	}
+	w.result.skipped = append(w.result.skipped, SkippedFile{Path: path, Reason: fmt.Sprintf("无法读取: %v", err)})
+	return nil
+}
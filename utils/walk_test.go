@@ -0,0 +1,109 @@
+//go:build !windows
+
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestArchiveFilePolicies(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "app")
+	if err := os.MkdirAll(filepath.Join(source, "shared"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "shared", "a.txt"), []byte("shared"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"link.txt": "shared/a.txt",
+		"dir":      "shared",
+		"loop":     ".",
+		"broken":   "missing.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(source, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Mkfifo(filepath.Join(source, "pipe"), 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	readZip := func(opts ArchiveOptions) (map[string]string, *ArchiveInfo, error) {
+		var buf bytes.Buffer
+		info, err := ZipToWriter([]string{source}, &buf, opts, func(string, int64, int64, float64) {}, func(int64) {})
+		if err != nil {
+			return nil, nil, err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			files[f.Name] = string(data)
+			if f.Mode()&os.ModeSymlink != 0 {
+				files[f.Name] = "-> " + string(data)
+			}
+		}
+		return files, info, nil
+	}
+
+	// 默认保存链接本身，跳过 FIFO
+	files, info, err := readZip(ArchiveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["app/link.txt"] != "-> shared/a.txt" || files["app/loop"] != "-> ." || files["app/broken"] != "-> missing.txt" {
+		t.Errorf("symlinks not stored: %v", files)
+	}
+	if len(info.Skipped) != 1 || !strings.HasSuffix(info.Skipped[0].Path, "pipe") {
+		t.Errorf("skipped = %v, want pipe", info.Skipped)
+	}
+
+	if _, _, err := readZip(ArchiveOptions{SpecialFiles: PolicyFail}); err == nil {
+		t.Error("special file should fail")
+	}
+
+	// 跟随链接时，断开的链接按无法读取处理
+	if _, _, err := readZip(ArchiveOptions{Symlinks: SymlinkFollow}); err == nil {
+		t.Error("broken symlink should fail")
+	}
+
+	files, info, err = readZip(ArchiveOptions{Symlinks: SymlinkFollow, Unreadable: PolicySkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["app/link.txt"] != "shared" || files["app/dir/a.txt"] != "shared" {
+		t.Errorf("symlinks not followed: %v", files)
+	}
+	reasons := map[string]bool{}
+	for _, f := range info.Skipped {
+		reasons[filepath.Base(f.Path)] = true
+	}
+	for _, name := range []string{"pipe", "loop", "broken"} {
+		if !reasons[name] {
+			t.Errorf("%s should be skipped, got %v", name, info.Skipped)
+		}
+	}
+
+	if err := (ArchiveOptions{Symlinks: "copy"}).Validate(); err == nil {
+		t.Error("invalid symlinks policy should fail")
+	}
+}
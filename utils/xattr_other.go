@@ -0,0 +1,8 @@
+//go:build !linux
+
+package utils
+
+// readXattrs 非 Linux 平台不保存扩展属性
+func readXattrs(string) (map[string]string, error) {
+	return nil, nil
+}